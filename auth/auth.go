@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"app/config"
+	"app/db"
+	"app/model"
+	"errors"
+	"fmt"
+)
+
+var (
+	// Signed in with the directory but no group maps to a role
+	ErrNOROLE = errors.New("No role assigned")
+)
+
+// Checks sign-in credentials and returns the signed in user.
+// Wrong credentials fail with db.ErrINVALIDPASSWORD whatever the backend, so
+// the sign-in handler can count them the same way.
+type Authenticator interface {
+	Authenticate(email, password string) (model.User, error)
+	// Whether the backend manages passwords in the user collection, i.e.
+	// sign-up, password change and password reset make sense.
+	LocalPasswords() bool
+}
+
+// bcrypt passwords of the user collection
+type Local struct {
+	DB db.DBInterface
+}
+
+func (l *Local) Authenticate(email, password string) (model.User, error) {
+	return l.DB.UserSignIn(email, password)
+}
+
+func (l *Local) LocalPasswords() bool {
+	return true
+}
+
+// Tries each authenticator in order until one knows the credentials,
+// e.g. the directory first and a local break-glass admin after it.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(email, password string) (model.User, error) {
+	err := db.ErrINVALIDPASSWORD
+	for _, a := range c {
+		var user model.User
+		user, err = a.Authenticate(email, password)
+		if err != db.ErrINVALIDPASSWORD {
+			return user, err
+		}
+	}
+	return model.User{}, err
+}
+
+func (c Chain) LocalPasswords() bool {
+	for _, a := range c {
+		if a.LocalPasswords() {
+			return true
+		}
+	}
+	return false
+}
+
+// Build the authenticator of the configured backends, tried in order
+func New(cfg config.Auth, ldap config.LDAP, client db.DBInterface) (Authenticator, error) {
+	var chain Chain
+	for _, name := range cfg.Backends {
+		switch name {
+		case "local":
+			chain = append(chain, &Local{DB: client})
+		case "ldap":
+			a, err := newLDAP(ldap, client)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, a)
+		default:
+			return nil, fmt.Errorf("unknown auth backend: %s", name)
+		}
+	}
+
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+	return chain, nil
+}
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"app/config"
+	"app/db"
+	"app/model"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Matches accounts by e-mail, UPN or Windows account name
+const defaultUserFilter = "(&(objectClass=user)(|(mail=%[1]s)(userPrincipalName=%[1]s)(sAMAccountName=%[1]s)))"
+
+// Account found in a directory
+type DirectoryEntry struct {
+	Email     string
+	FirstName string
+	LastName  string
+	Groups    []string // group DNs
+}
+
+// A directory of accounts: an LDAP / Active Directory server, or a stand-in
+type Directory interface {
+	// Check the password of the account and return its entry.
+	// Wrong credentials fail with db.ErrINVALIDPASSWORD.
+	Bind(username, password string) (DirectoryEntry, error)
+}
+
+// Signs in against a directory, maps its groups to roles and provisions the
+// user record on first sign-in. No password is stored in the user collection.
+type DirectoryAuthenticator struct {
+	Directory   Directory
+	GroupRoles  map[string]string // group DN, or just its CN, to role
+	DefaultRole string            // for accounts in no mapped group, empty to refuse them
+	Source      string
+	DB          db.DBInterface
+}
+
+func (a *DirectoryAuthenticator) Authenticate(email, password string) (model.User, error) {
+	if password == "" { // an empty password is an anonymous bind on most servers
+		return model.User{}, db.ErrINVALIDPASSWORD
+	}
+	entry, err := a.Directory.Bind(email, password)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	roles := a.roles(entry.Groups)
+	if len(roles) == 0 {
+		return model.User{}, ErrNOROLE
+	}
+	if entry.Email == "" {
+		entry.Email = email
+	}
+
+	user, err := a.DB.ProvisionUser(model.User{
+		Email:     entry.Email,
+		FirstName: entry.FirstName,
+		LastName:  entry.LastName,
+		Roles:     roles,
+		Source:    a.Source,
+	})
+	if err != nil {
+		return user, err
+	}
+	if user.Disabled {
+		return user, db.ErrDISABLED
+	}
+	return user, nil
+}
+
+func (a *DirectoryAuthenticator) LocalPasswords() bool {
+	return false
+}
+
+func (a *DirectoryAuthenticator) roles(groups []string) []string {
+	var roles []string
+	for group, role := range a.GroupRoles {
+		for _, member := range groups {
+			if groupMatches(group, member) {
+				roles = append(roles, role)
+				break
+			}
+		}
+	}
+	if len(roles) == 0 && a.DefaultRole != "" {
+		roles = []string{a.DefaultRole}
+	}
+	return roles
+}
+
+// Compare group DNs, or only the CN when the configured group is not a DN
+func groupMatches(configured, member string) bool {
+	if strings.Contains(configured, "=") {
+		return strings.EqualFold(configured, member)
+	}
+	dn, err := ldap.ParseDN(member)
+	if err != nil || len(dn.RDNs) == 0 {
+		return strings.EqualFold(configured, member)
+	}
+	for _, attr := range dn.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") && strings.EqualFold(attr.Value, configured) {
+			return true
+		}
+	}
+	return false
+}
+
+// LDAP / Active Directory server
+type LDAPDirectory struct {
+	URL          string
+	StartTLS     bool
+	BindDN       string // service account used to find users
+	BindPassword string
+	BaseDN       string
+	UserFilter   string // %[1]s is replaced by the escaped username
+}
+
+func (d *LDAPDirectory) Bind(username, password string) (DirectoryEntry, error) {
+	var entry DirectoryEntry
+
+	conn, err := ldap.DialURL(d.URL)
+	if err != nil {
+		return entry, err
+	}
+	defer conn.Close()
+
+	if d.StartTLS {
+		host := strings.Split(strings.TrimPrefix(strings.TrimPrefix(d.URL, "ldap://"), "ldaps://"), ":")[0]
+		if err := conn.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return entry, err
+		}
+	}
+
+	if d.BindDN != "" {
+		if err := conn.Bind(d.BindDN, d.BindPassword); err != nil {
+			return entry, fmt.Errorf("ldap service bind: %v", err)
+		}
+	}
+
+	filter := d.UserFilter
+	if filter == "" {
+		filter = defaultUserFilter
+	}
+	req := ldap.NewSearchRequest(d.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 10, false,
+		fmt.Sprintf(filter, ldap.EscapeFilter(username)),
+		[]string{"mail", "userPrincipalName", "givenName", "sn", "memberOf"}, nil)
+	result, err := conn.Search(req)
+	if err != nil {
+		return entry, err
+	}
+	if len(result.Entries) != 1 {
+		return entry, db.ErrINVALIDPASSWORD
+	}
+	found := result.Entries[0]
+
+	if err := conn.Bind(found.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return entry, db.ErrINVALIDPASSWORD
+		}
+		return entry, err
+	}
+
+	entry.Email = found.GetAttributeValue("mail")
+	if entry.Email == "" {
+		entry.Email = found.GetAttributeValue("userPrincipalName")
+	}
+	entry.FirstName = found.GetAttributeValue("givenName")
+	entry.LastName = found.GetAttributeValue("sn")
+	entry.Groups = found.GetAttributeValues("memberOf")
+	return entry, nil
+}
+
+// Stand-in for an LDAP server, read from a JSON file, to try the directory
+// sign-in without a domain controller. Passwords are bcrypt hashes or plain text.
+type StaticDirectory struct {
+	Accounts []StaticAccount
+}
+
+type StaticAccount struct {
+	Username  string   `json:"username"`
+	Email     string   `json:"email"`
+	Password  string   `json:"password"`
+	FirstName string   `json:"firstname"`
+	LastName  string   `json:"lastname"`
+	Groups    []string `json:"groups"`
+}
+
+func LoadStaticDirectory(path string) (*StaticDirectory, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &StaticDirectory{}
+	if err := json.Unmarshal(data, &d.Accounts); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return d, nil
+}
+
+func (d *StaticDirectory) Bind(username, password string) (DirectoryEntry, error) {
+	for _, a := range d.Accounts {
+		if !strings.EqualFold(a.Username, username) && !strings.EqualFold(a.Email, username) {
+			continue
+		}
+		if !staticPasswordMatches(a.Password, password) {
+			return DirectoryEntry{}, db.ErrINVALIDPASSWORD
+		}
+		return DirectoryEntry{Email: a.Email, FirstName: a.FirstName, LastName: a.LastName, Groups: a.Groups}, nil
+	}
+	return DirectoryEntry{}, db.ErrINVALIDPASSWORD
+}
+
+func staticPasswordMatches(stored, password string) bool {
+	if strings.HasPrefix(stored, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// The directory of the configuration, a StaticDirectory when it has a stand-in file
+func newLDAP(cfg config.LDAP, client db.DBInterface) (*DirectoryAuthenticator, error) {
+	groupRoles, err := config.ParseRoleMapping(cfg.GroupRoles)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DefaultRole != "" && !model.ValidRole(cfg.DefaultRole) {
+		return nil, fmt.Errorf("unknown role: %s", cfg.DefaultRole)
+	}
+
+	var directory Directory
+	if cfg.StandinFile != "" {
+		if directory, err = LoadStaticDirectory(cfg.StandinFile); err != nil {
+			return nil, err
+		}
+	} else {
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("ldap.url is required for the ldap auth backend")
+		}
+		directory = &LDAPDirectory{
+			URL:          cfg.URL,
+			StartTLS:     cfg.StartTLS,
+			BindDN:       cfg.BindDN,
+			BindPassword: cfg.BindPassword,
+			BaseDN:       cfg.BaseDN,
+			UserFilter:   cfg.UserFilter,
+		}
+	}
+
+	return &DirectoryAuthenticator{
+		Directory:   directory,
+		GroupRoles:  groupRoles,
+		DefaultRole: cfg.DefaultRole,
+		Source:      "ldap",
+		DB:          client,
+	}, nil
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"app/db"
+	"app/model"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Users provisioned by the authenticators, the other DBInterface methods are not used
+type fakeDB struct {
+	db.DBInterface
+	users map[string]model.User
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{users: map[string]model.User{}}
+}
+
+func (f *fakeDB) ProvisionUser(user model.User) (model.User, error) {
+	if existing, ok := f.users[user.Email]; ok {
+		if existing.Source != user.Source {
+			return model.User{}, db.ErrSOURCE
+		}
+		user.Disabled = existing.Disabled
+	}
+	f.users[user.Email] = user
+	return user, nil
+}
+
+func testDirectory(t *testing.T) *StaticDirectory {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed1pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &StaticDirectory{Accounts: []StaticAccount{
+		{Username: "alice", Email: "alice@example.com", Password: "alice1pass", FirstName: "Alice", LastName: "Admin",
+			Groups: []string{"CN=Plant Admins,OU=Groups,DC=example,DC=com"}},
+		{Username: "bob", Email: "bob@example.com", Password: string(hash), FirstName: "Bob", LastName: "Operator",
+			Groups: []string{"CN=Operators,OU=Groups,DC=example,DC=com", "CN=Viewers,OU=Groups,DC=example,DC=com"}},
+		{Username: "carol", Email: "carol@example.com", Password: "carol1pass",
+			Groups: []string{"CN=Contractors,OU=Groups,DC=example,DC=com"}},
+	}}
+}
+
+func testAuthenticator(t *testing.T, store *fakeDB) *DirectoryAuthenticator {
+	return &DirectoryAuthenticator{
+		Directory: testDirectory(t),
+		GroupRoles: map[string]string{
+			"CN=Plant Admins,OU=Groups,DC=example,DC=com": model.RoleAdmin,
+			"Operators": model.RoleOperator,
+			"viewers":   model.RoleViewer,
+		},
+		Source: "ldap",
+		DB:     store,
+	}
+}
+
+func TestDirectoryGroupRoles(t *testing.T) {
+	a := testAuthenticator(t, newFakeDB())
+
+	tests := []struct {
+		email, password string
+		roles           []string
+	}{
+		{"alice", "alice1pass", []string{model.RoleAdmin}},
+		{"bob@example.com", "hashed1pass", []string{model.RoleOperator, model.RoleViewer}},
+	}
+	for _, tt := range tests {
+		user, err := a.Authenticate(tt.email, tt.password)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.email, err)
+		}
+		sort.Strings(user.Roles)
+		sort.Strings(tt.roles)
+		if !reflect.DeepEqual(user.Roles, tt.roles) {
+			t.Errorf("%s: roles %v, want %v", tt.email, user.Roles, tt.roles)
+		}
+	}
+}
+
+func TestDirectoryProvisionsOnFirstSignIn(t *testing.T) {
+	store := newFakeDB()
+	a := testAuthenticator(t, store)
+
+	if _, err := a.Authenticate("alice", "alice1pass"); err != nil {
+		t.Fatal(err)
+	}
+	user, ok := store.users["alice@example.com"]
+	if !ok {
+		t.Fatal("user not provisioned")
+	}
+	if user.Source != "ldap" || user.FirstName != "Alice" || user.LastName != "Admin" || user.Password != "" {
+		t.Errorf("provisioned %+v", user)
+	}
+}
+
+func TestDirectoryWrongPassword(t *testing.T) {
+	store := newFakeDB()
+	a := testAuthenticator(t, store)
+
+	for _, password := range []string{"wrong1pass", ""} {
+		if _, err := a.Authenticate("alice", password); err != db.ErrINVALIDPASSWORD {
+			t.Errorf("password %q: err %v, want %v", password, err, db.ErrINVALIDPASSWORD)
+		}
+	}
+	if _, err := a.Authenticate("nobody", "alice1pass"); err != db.ErrINVALIDPASSWORD {
+		t.Errorf("unknown account: err %v, want %v", err, db.ErrINVALIDPASSWORD)
+	}
+	if len(store.users) != 0 {
+		t.Errorf("provisioned %v", store.users)
+	}
+}
+
+func TestDirectoryNoMappedRole(t *testing.T) {
+	store := newFakeDB()
+	a := testAuthenticator(t, store)
+
+	if _, err := a.Authenticate("carol", "carol1pass"); err != ErrNOROLE {
+		t.Errorf("err %v, want %v", err, ErrNOROLE)
+	}
+	if len(store.users) != 0 {
+		t.Errorf("provisioned %v", store.users)
+	}
+
+	a.DefaultRole = model.RoleViewer
+	user, err := a.Authenticate("carol", "carol1pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(user.Roles, []string{model.RoleViewer}) {
+		t.Errorf("roles %v, want the default role", user.Roles)
+	}
+}
+
+func TestDirectoryRefusesLocalAccount(t *testing.T) {
+	store := newFakeDB()
+	store.users["alice@example.com"] = model.User{Email: "alice@example.com", Roles: []string{model.RoleSuperAdmin}}
+	a := testAuthenticator(t, store)
+
+	if _, err := a.Authenticate("alice", "alice1pass"); err != db.ErrSOURCE {
+		t.Errorf("err %v, want %v", err, db.ErrSOURCE)
+	}
+	if roles := store.users["alice@example.com"].Roles; !reflect.DeepEqual(roles, []string{model.RoleSuperAdmin}) {
+		t.Errorf("local account roles changed to %v", roles)
+	}
+}
+
+func TestLoadStaticDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "accounts.json")
+	data := `[{"username": "dave", "email": "dave@example.com", "password": "dave1pass", "groups": ["CN=Viewers,DC=example,DC=com"]}]`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := LoadStaticDirectory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := d.Bind("dave", "dave1pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Email != "dave@example.com" || len(entry.Groups) != 1 {
+		t.Errorf("entry %+v", entry)
+	}
+}
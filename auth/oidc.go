@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"app/config"
+	"app/db"
+	"app/model"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrIDTOKEN = errors.New("Invalid ID token")
+)
+
+// OpenID Connect authorization code login with an identity provider.
+// Users are created on their first login, roles follow the role claim.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	RoleClaim    string            // claim holding the user's groups or roles
+	ClaimRoles   map[string]string // claim value to role
+	DefaultRole  string            // for users with no mapped value, empty to refuse them
+	PostLoginURL string            // SPA page receiving the tokens in the URL fragment
+	DB           db.DBInterface
+	HTTPClient   *http.Client
+
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{} // JWKS by key id
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// The provider of the configuration, nil when it has no issuer
+func NewOIDC(cfg config.OIDC, client db.DBInterface) (*OIDCProvider, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("oidc.client_id and oidc.redirect_url are required with oidc.issuer")
+	}
+
+	claimRoles, err := config.ParseRoleMapping(cfg.ClaimRoles)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DefaultRole != "" && !model.ValidRole(cfg.DefaultRole) {
+		return nil, fmt.Errorf("unknown role: %s", cfg.DefaultRole)
+	}
+
+	return &OIDCProvider{
+		Issuer:       strings.TrimSuffix(cfg.Issuer, "/"),
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Scopes:       cfg.Scopes,
+		RoleClaim:    cfg.RoleClaim,
+		ClaimRoles:   claimRoles,
+		DefaultRole:  cfg.DefaultRole,
+		PostLoginURL: cfg.PostLoginURL,
+		DB:           client,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *OIDCProvider) getJSON(u string, v interface{}) error {
+	resp, err := p.HTTPClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Provider metadata, fetched once
+func (p *OIDCProvider) metadata() (*oidcDiscovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d oidcDiscovery
+	if err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc issuer mismatch: %s", d.Issuer)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// Signing key by id. The key set is fetched again for unknown ids, the provider may have rotated it.
+func (p *OIDCProvider) key(kid string) (interface{}, error) {
+	p.mutex.Lock()
+	key, ok := p.keys[kid]
+	p.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	d, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+
+	p.mutex.Lock()
+	p.keys = keys
+	p.mutex.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key: %s", kid)
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+}
+
+// PKCE S256 challenge of a verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// URL of the provider login page
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	d, err := p.metadata()
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(p.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange the authorization code for the raw ID token
+func (p *OIDCProvider) Exchange(code, verifier string) (string, error) {
+	d, err := p.metadata()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", verifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	resp, err := p.HTTPClient.PostForm(d.TokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("oidc token exchange failed: %s %s", resp.Status, body.Error)
+	}
+	return body.IDToken, nil
+}
+
+// Verify the ID token signature against the provider keys, its issuer,
+// audience, expiry and nonce, and return its claims
+func (p *OIDCProvider) Verify(raw, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodRSAPSS:
+		default:
+			return nil, ErrIDTOKEN
+		}
+		kid, _ := t.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrIDTOKEN
+	}
+	if !claims.VerifyIssuer(p.Issuer, true) || !claims.VerifyAudience(p.ClientID, true) ||
+		!claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, ErrIDTOKEN
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, ErrIDTOKEN
+	}
+	return claims, nil
+}
+
+// Whether the provider says the user signed in with more than a password
+func MultiFactor(claims jwt.MapClaims) bool {
+	for _, m := range stringList(claims["amr"]) {
+		switch m {
+		case "mfa", "otp", "hwk", "swk", "sms":
+			return true
+		}
+	}
+	return false
+}
+
+// Create or update the user of verified ID token claims
+func (p *OIDCProvider) Provision(claims jwt.MapClaims) (model.User, error) {
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return model.User{}, ErrIDTOKEN
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return model.User{}, ErrIDTOKEN
+	}
+
+	var roles []string
+	for _, value := range stringList(claims[p.RoleClaim]) {
+		if role, ok := p.ClaimRoles[value]; ok {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 && p.DefaultRole != "" {
+		roles = []string{p.DefaultRole}
+	}
+	if len(roles) == 0 {
+		return model.User{}, ErrNOROLE
+	}
+
+	firstName, _ := claims["given_name"].(string)
+	lastName, _ := claims["family_name"].(string)
+	user, err := p.DB.ProvisionUser(model.User{
+		Email:     email,
+		FirstName: firstName,
+		LastName:  lastName,
+		Roles:     roles,
+		Source:    "oidc",
+	})
+	if err != nil {
+		return user, err
+	}
+	if user.Disabled {
+		return user, db.ErrDISABLED
+	}
+	return user, nil
+}
+
+// A claim that is a string or a list of strings
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"app/db"
+	"app/model"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Local OpenID provider serving discovery, its key set and a token endpoint
+// checking the PKCE verifier of the codes it issued
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+	// code to its PKCE challenge and the ID token returned for it
+	codes map[string]mockCode
+}
+
+type mockCode struct {
+	challenge string
+	idToken   string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key, kid: "test-key", codes: map[string]mockCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                m.server.URL,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint:         m.server.URL + "/token",
+			JWKSURI:               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			Kid: m.kid,
+			Kty: "RSA",
+			Use: "sig",
+			N:   encode(key.PublicKey.N.Bytes()),
+			E:   encode(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		code, ok := m.codes[r.PostFormValue("code")]
+		if !ok || CodeChallenge(r.PostFormValue("code_verifier")) != code.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": code.idToken})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// ID token for the test client, changed by edit before it is signed
+func (m *mockProvider) token(t *testing.T, key *rsa.PrivateKey, edit func(jwt.MapClaims)) string {
+	claims := jwt.MapClaims{
+		"iss":            m.server.URL,
+		"aud":            "test-client",
+		"sub":            "1234",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"nonce":          "test-nonce",
+		"email":          "erin@example.com",
+		"email_verified": true,
+		"given_name":     "Erin",
+		"family_name":    "Engineer",
+		"groups":         []string{"plant-operators"},
+	}
+	if edit != nil {
+		edit(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.kid
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func (m *mockProvider) client(store db.DBInterface) *OIDCProvider {
+	return &OIDCProvider{
+		Issuer:      m.server.URL,
+		ClientID:    "test-client",
+		RedirectURL: "https://app.example.com/user/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+		RoleClaim:   "groups",
+		ClaimRoles:  map[string]string{"plant-operators": model.RoleOperator},
+		DB:          store,
+		HTTPClient:  m.server.Client(),
+	}
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.client(newFakeDB())
+
+	raw, err := p.AuthCodeURL("test-state", "test-nonce", "test-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw, m.server.URL+"/authorize?") {
+		t.Errorf("url %s", raw)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "test-client",
+		"state":                 "test-state",
+		"nonce":                 "test-nonce",
+		"code_challenge":        CodeChallenge("test-verifier"),
+		"code_challenge_method": "S256",
+	}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+}
+
+func TestOIDCExchangePKCE(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.client(newFakeDB())
+	idToken := m.token(t, m.key, nil)
+	m.codes["test-code"] = mockCode{challenge: CodeChallenge("test-verifier"), idToken: idToken}
+
+	raw, err := p.Exchange("test-code", "test-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw != idToken {
+		t.Error("unexpected ID token")
+	}
+	if _, err := p.Exchange("test-code", "other-verifier"); err == nil {
+		t.Error("exchange with the wrong verifier succeeded")
+	}
+}
+
+func TestOIDCVerify(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.client(newFakeDB())
+
+	if _, err := p.Verify(m.token(t, m.key, nil), "test-nonce"); err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		raw   string
+		nonce string
+	}{
+		{"signature", m.token(t, otherKey, nil), "test-nonce"},
+		{"issuer", m.token(t, m.key, func(c jwt.MapClaims) { c["iss"] = "https://other.example.com" }), "test-nonce"},
+		{"audience", m.token(t, m.key, func(c jwt.MapClaims) { c["aud"] = "other-client" }), "test-nonce"},
+		{"expired", m.token(t, m.key, func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }), "test-nonce"},
+		{"no expiry", m.token(t, m.key, func(c jwt.MapClaims) { delete(c, "exp") }), "test-nonce"},
+		{"nonce", m.token(t, m.key, nil), "other-nonce"},
+		{"unsigned", unsignedToken(t), "test-nonce"},
+	}
+	for _, tt := range tests {
+		if _, err := p.Verify(tt.raw, tt.nonce); err != ErrIDTOKEN {
+			t.Errorf("%s: err %v, want %v", tt.name, err, ErrIDTOKEN)
+		}
+	}
+}
+
+func unsignedToken(t *testing.T) string {
+	raw, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"nonce": "test-nonce"}).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestOIDCProvisionRoles(t *testing.T) {
+	m := newMockProvider(t)
+	store := newFakeDB()
+	p := m.client(store)
+
+	claims, err := p.Verify(m.token(t, m.key, nil), "test-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := p.Provision(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(user.Roles, []string{model.RoleOperator}) || user.Source != "oidc" || user.FirstName != "Erin" {
+		t.Errorf("provisioned %+v", user)
+	}
+
+	unmapped := jwt.MapClaims{"email": "frank@example.com", "groups": []interface{}{"visitors"}}
+	if _, err := p.Provision(unmapped); err != ErrNOROLE {
+		t.Errorf("unmapped group: err %v, want %v", err, ErrNOROLE)
+	}
+	p.DefaultRole = model.RoleViewer
+	user, err = p.Provision(unmapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(user.Roles, []string{model.RoleViewer}) {
+		t.Errorf("roles %v, want the default role", user.Roles)
+	}
+
+	unverified := jwt.MapClaims{"email": "gina@example.com", "email_verified": false, "groups": "plant-operators"}
+	if _, err := p.Provision(unverified); err != ErrIDTOKEN {
+		t.Errorf("unverified e-mail: err %v, want %v", err, ErrIDTOKEN)
+	}
+}
+
+func TestOIDCRefusesLocalAccount(t *testing.T) {
+	m := newMockProvider(t)
+	store := newFakeDB()
+	store.users["erin@example.com"] = model.User{Email: "erin@example.com", Roles: []string{model.RoleSuperAdmin}, TOTPEnabled: true}
+	p := m.client(store)
+
+	claims, err := p.Verify(m.token(t, m.key, nil), "test-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Provision(claims); err != db.ErrSOURCE {
+		t.Errorf("err %v, want %v", err, db.ErrSOURCE)
+	}
+}
+
+func TestOIDCMultiFactor(t *testing.T) {
+	if MultiFactor(jwt.MapClaims{"amr": []interface{}{"pwd"}}) {
+		t.Error("password only reported as multi-factor")
+	}
+	if !MultiFactor(jwt.MapClaims{"amr": []interface{}{"pwd", "otp"}}) {
+		t.Error("one-time password not reported as multi-factor")
+	}
+}
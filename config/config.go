@@ -0,0 +1,566 @@
+package config
+
+import (
+	"app/model"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Server configuration, from the lowest to the highest precedence: defaults,
+// the config file, environment variables and command-line flags.
+type Config struct {
+	Mongo    Mongo    `yaml:"mongo" json:"mongo"`
+	HTTP     HTTP     `yaml:"http" json:"http"`
+	TLS      TLS      `yaml:"tls" json:"tls"`
+	Security Security `yaml:"security" json:"security"`
+	FFmpeg   FFmpeg   `yaml:"ffmpeg" json:"ffmpeg"`
+	Auth     Auth     `yaml:"auth" json:"auth"`
+	LDAP     LDAP     `yaml:"ldap" json:"ldap"`
+	OIDC     OIDC     `yaml:"oidc" json:"oidc"`
+	Notify   Notify   `yaml:"notify" json:"notify"`
+
+	// only from the command line
+	File        string   `yaml:"-" json:"-"`
+	PrintConfig bool     `yaml:"-" json:"-"`
+	Args        []string `yaml:"-" json:"-"` // command and its arguments after the flags
+}
+
+type Mongo struct {
+	URI      string `yaml:"uri" json:"uri"`           // may hold credentials
+	Database string `yaml:"database" json:"database"` // tenants get their own database with this prefix
+	// how long to retry reaching the server at startup before giving up, 0 to retry forever
+	StartupTimeout string `yaml:"startup_timeout" json:"startup_timeout"`
+}
+
+// Startup timeout as a duration, the configuration must be valid
+func (m Mongo) StartupTimeoutDuration() time.Duration {
+	d, _ := time.ParseDuration(m.StartupTimeout)
+	return d
+}
+
+type HTTP struct {
+	Address   string `yaml:"address" json:"address"`       // listen address, like ":5000"
+	StaticDir string `yaml:"static_dir" json:"static_dir"` // built SPA
+	// how long to wait for the streams to end on SIGINT or SIGTERM
+	ShutdownTimeout string `yaml:"shutdown_timeout" json:"shutdown_timeout"`
+	// CIDRs of the reverse proxies whose X-Forwarded-For is trusted for the
+	// client address, empty to use the address of the connection
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies"`
+	// plain HTTP listen address serving /metrics to Prometheus without
+	// authentication, like "127.0.0.1:9090", empty for none. The HTTP address
+	// serves them to super-admins only.
+	MetricsAddress string `yaml:"metrics_address" json:"metrics_address"`
+}
+
+// Shutdown timeout as a duration, the configuration must be valid
+func (h HTTP) ShutdownTimeoutDuration() time.Duration {
+	d, _ := time.ParseDuration(h.ShutdownTimeout)
+	return d
+}
+
+// HTTPS on the HTTP address, the files are read again when they change
+type TLS struct {
+	Cert string `yaml:"cert" json:"cert"` // PEM certificate chain, empty to serve plain HTTP
+	Key  string `yaml:"key" json:"key"`   // PEM private key
+	// plain HTTP listen address redirecting to HTTPS, like ":80", empty for none
+	RedirectAddress string `yaml:"redirect_address" json:"redirect_address"`
+	// PEM CAs of the client certificates, empty to not ask clients for one
+	ClientCA string `yaml:"client_ca" json:"client_ca"`
+	// optional checks the certificates clients send, require refuses clients without one
+	ClientAuth string `yaml:"client_auth" json:"client_auth"`
+}
+
+// Whether the server serves HTTPS
+func (t TLS) Enabled() bool {
+	return t.Cert != ""
+}
+
+// Cross-origin access and the security headers of the responses
+type Security struct {
+	// origins allowed to call the API and open streams from another site, like
+	// the SPA dev server "http://localhost:3000", "*" for any
+	CORSOrigins []string `yaml:"cors_origins" json:"cors_origins"`
+	CORSMethods []string `yaml:"cors_methods" json:"cors_methods"`
+	// Content-Security-Policy of the responses, empty for none
+	ContentSecurityPolicy string `yaml:"content_security_policy" json:"content_security_policy"`
+	FrameOptions          string `yaml:"frame_options" json:"frame_options"` // DENY or SAMEORIGIN
+	HSTSMaxAge            int    `yaml:"hsts_max_age" json:"hsts_max_age"`   // seconds, sent with HTTPS only, 0 for none
+}
+
+// Options of the ffmpeg command transcoding camera streams
+type FFmpeg struct {
+	Path      string   `yaml:"path" json:"path"`
+	InputArgs []string `yaml:"input_args" json:"input_args"` // before -i, like ["-rtsp_transport", "tcp"]
+	Bitrate   string   `yaml:"bitrate" json:"bitrate"`       // video bitrate, like "2M"
+}
+
+// Sign-in and access tokens
+type Auth struct {
+	// tried in order until one knows the credentials, "local" and "ldap"
+	Backends []string `yaml:"backends" json:"backends"`
+	// signs the access tokens, empty for a random one that restarts invalidate
+	JWTSecret string `yaml:"jwt_secret" json:"jwt_secret"`
+}
+
+// Directory of the "ldap" auth backend
+type LDAP struct {
+	URL          string `yaml:"url" json:"url"` // ldap:// or ldaps://
+	StartTLS     bool   `yaml:"starttls" json:"starttls"`
+	BindDN       string `yaml:"bind_dn" json:"bind_dn"` // service account searching the users
+	BindPassword string `yaml:"bind_password" json:"bind_password"`
+	BaseDN       string `yaml:"base_dn" json:"base_dn"`
+	UserFilter   string `yaml:"user_filter" json:"user_filter"`
+	// "role:group;role:group" where group is a DN or a CN
+	GroupRoles  string `yaml:"group_roles" json:"group_roles"`
+	DefaultRole string `yaml:"default_role" json:"default_role"` // for users in no mapped group, empty to refuse them
+	// JSON accounts replacing the server, for development and tests
+	StandinFile string `yaml:"standin_file" json:"standin_file"`
+}
+
+// OpenID Connect single sign-on, disabled without an issuer
+type OIDC struct {
+	Issuer       string   `yaml:"issuer" json:"issuer"`
+	ClientID     string   `yaml:"client_id" json:"client_id"`
+	ClientSecret string   `yaml:"client_secret" json:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url" json:"redirect_url"`
+	Scopes       []string `yaml:"scopes" json:"scopes"`
+	RoleClaim    string   `yaml:"role_claim" json:"role_claim"` // claim holding the user's groups or roles
+	// "role:value;role:value" of the role claim
+	ClaimRoles   string `yaml:"claim_roles" json:"claim_roles"`
+	DefaultRole  string `yaml:"default_role" json:"default_role"`
+	PostLoginURL string `yaml:"post_login_url" json:"post_login_url"` // SPA page receiving the tokens
+}
+
+// Messages to users, like password resets
+type Notify struct {
+	File string `yaml:"file" json:"file"` // appended to, empty to write them to the log
+}
+
+// Whether single sign-on is configured
+func (o OIDC) Enabled() bool {
+	return o.Issuer != ""
+}
+
+var (
+	// tenants get "<database>_<tenant>" with up to 32 characters of tenant,
+	// MongoDB database names must be shorter than 64 bytes
+	dbNamePattern  = regexp.MustCompile(`^[A-Za-z0-9_-]{1,30}$`)
+	bitratePattern = regexp.MustCompile(`^[0-9]+[kKM]?$`)
+	corsMethods    = map[string]bool{"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "OPTIONS": true}
+)
+
+func Default() Config {
+	return Config{
+		Mongo: Mongo{
+			URI:            "mongodb://localhost:27017",
+			Database:       "testApp",
+			StartupTimeout: "1m",
+		},
+		HTTP: HTTP{
+			Address:         ":5000",
+			StaticDir:       "build",
+			ShutdownTimeout: "15s",
+		},
+		TLS: TLS{
+			ClientAuth: "optional",
+		},
+		Security: Security{
+			CORSMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			// the SPA loads its own bundles, inline styles, and video from WebRTC blobs
+			ContentSecurityPolicy: "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; " +
+				"img-src 'self' data: blob:; media-src 'self' blob:; connect-src 'self' ws: wss:; " +
+				"object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+			FrameOptions: "DENY",
+			HSTSMaxAge:   31536000,
+		},
+		FFmpeg: FFmpeg{
+			Path:    "ffmpeg",
+			Bitrate: "2M",
+		},
+		Auth: Auth{
+			Backends: []string{"local"},
+		},
+		OIDC: OIDC{
+			Scopes:       []string{"openid", "email", "profile"},
+			RoleClaim:    "groups",
+			PostLoginURL: "/",
+		},
+	}
+}
+
+// Load the configuration for the command-line arguments. The config file is
+// given with --config or CONFIG_FILE, JSON when it ends with .json, YAML otherwise.
+func Load(args []string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "config file (YAML or JSON)")
+	mongoURI := fs.String("mongo-uri", "", "MongoDB connection string")
+	mongoDatabase := fs.String("mongo-database", "", "MongoDB database name")
+	mongoStartupTimeout := fs.String("mongo-startup-timeout", "", "how long to retry reaching MongoDB at startup, 0 to retry forever")
+	address := fs.String("addr", "", "HTTP listen address")
+	staticDir := fs.String("static-dir", "", "directory of the built SPA")
+	metricsAddress := fs.String("metrics-addr", "", "listen address serving /metrics without authentication")
+	shutdownTimeout := fs.String("shutdown-timeout", "", "how long to wait for the streams to end on shutdown")
+	tlsCert := fs.String("tls-cert", "", "PEM certificate chain, enables HTTPS")
+	tlsKey := fs.String("tls-key", "", "PEM private key of the certificate")
+	tlsRedirect := fs.String("tls-redirect", "", "plain HTTP listen address redirecting to HTTPS")
+	tlsClientCA := fs.String("tls-client-ca", "", "PEM CAs of the client certificates, enables mutual TLS")
+	tlsClientAuth := fs.String("tls-client-auth", "", "optional or require a client certificate")
+	ffmpegPath := fs.String("ffmpeg", "", "ffmpeg binary")
+	ffmpegBitrate := fs.String("ffmpeg-bitrate", "", "video bitrate of the camera streams")
+	authBackends := fs.String("auth-backend", "", "comma separated sign-in backends, local and ldap")
+	notifyFile := fs.String("notify-file", "", "file the messages to users are appended to")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration, secrets redacted, and exit")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *file != "" {
+		if err := cfg.readFile(*file); err != nil {
+			return cfg, err
+		}
+		cfg.File = *file
+	}
+
+	cfg.applyEnv()
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "mongo-uri":
+			cfg.Mongo.URI = *mongoURI
+		case "mongo-database":
+			cfg.Mongo.Database = *mongoDatabase
+		case "mongo-startup-timeout":
+			cfg.Mongo.StartupTimeout = *mongoStartupTimeout
+		case "addr":
+			cfg.HTTP.Address = *address
+		case "static-dir":
+			cfg.HTTP.StaticDir = *staticDir
+		case "metrics-addr":
+			cfg.HTTP.MetricsAddress = *metricsAddress
+		case "shutdown-timeout":
+			cfg.HTTP.ShutdownTimeout = *shutdownTimeout
+		case "tls-cert":
+			cfg.TLS.Cert = *tlsCert
+		case "tls-key":
+			cfg.TLS.Key = *tlsKey
+		case "tls-redirect":
+			cfg.TLS.RedirectAddress = *tlsRedirect
+		case "tls-client-ca":
+			cfg.TLS.ClientCA = *tlsClientCA
+		case "tls-client-auth":
+			cfg.TLS.ClientAuth = *tlsClientAuth
+		case "ffmpeg":
+			cfg.FFmpeg.Path = *ffmpegPath
+		case "ffmpeg-bitrate":
+			cfg.FFmpeg.Bitrate = *ffmpegBitrate
+		case "auth-backend":
+			cfg.Auth.Backends = splitList(*authBackends)
+		case "notify-file":
+			cfg.Notify.File = *notifyFile
+		}
+	})
+	cfg.PrintConfig = *printConfig
+	cfg.Args = fs.Args()
+
+	return cfg, nil
+}
+
+func (cfg *Config) readFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, cfg)
+	} else {
+		err = yaml.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// Override the settings with the environment variables that are set
+func (cfg *Config) applyEnv() {
+	env := func(name string, dst *string) {
+		if v := os.Getenv(name); v != "" {
+			*dst = v
+		}
+	}
+	env("MONGO_URI", &cfg.Mongo.URI)
+	env("MONGO_DATABASE", &cfg.Mongo.Database)
+	env("MONGO_STARTUP_TIMEOUT", &cfg.Mongo.StartupTimeout)
+	env("HTTP_ADDRESS", &cfg.HTTP.Address)
+	env("STATIC_DIR", &cfg.HTTP.StaticDir)
+	env("HTTP_SHUTDOWN_TIMEOUT", &cfg.HTTP.ShutdownTimeout)
+	env("METRICS_ADDRESS", &cfg.HTTP.MetricsAddress)
+	env("TLS_CERT", &cfg.TLS.Cert)
+	env("TLS_KEY", &cfg.TLS.Key)
+	env("TLS_REDIRECT_ADDRESS", &cfg.TLS.RedirectAddress)
+	env("TLS_CLIENT_CA", &cfg.TLS.ClientCA)
+	env("TLS_CLIENT_AUTH", &cfg.TLS.ClientAuth)
+	env("FFMPEG_PATH", &cfg.FFmpeg.Path)
+	env("FFMPEG_BITRATE", &cfg.FFmpeg.Bitrate)
+	env("CONTENT_SECURITY_POLICY", &cfg.Security.ContentSecurityPolicy)
+	env("FRAME_OPTIONS", &cfg.Security.FrameOptions)
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		cfg.HTTP.TrustedProxies = strings.Fields(v)
+	}
+	if v := os.Getenv("CORS_ORIGINS"); v != "" {
+		cfg.Security.CORSOrigins = strings.Fields(v)
+	}
+	if v := os.Getenv("CORS_METHODS"); v != "" {
+		cfg.Security.CORSMethods = strings.Fields(v)
+	}
+	if v := os.Getenv("FFMPEG_INPUT_ARGS"); v != "" {
+		cfg.FFmpeg.InputArgs = strings.Fields(v)
+	}
+	if v := os.Getenv("AUTH_BACKEND"); v != "" {
+		cfg.Auth.Backends = splitList(v)
+	}
+	env("JWT_SECRET", &cfg.Auth.JWTSecret)
+	env("LDAP_URL", &cfg.LDAP.URL)
+	if v := os.Getenv("LDAP_STARTTLS"); v != "" {
+		cfg.LDAP.StartTLS = v == "true"
+	}
+	env("LDAP_BIND_DN", &cfg.LDAP.BindDN)
+	env("LDAP_BIND_PASSWORD", &cfg.LDAP.BindPassword)
+	env("LDAP_BASE_DN", &cfg.LDAP.BaseDN)
+	env("LDAP_USER_FILTER", &cfg.LDAP.UserFilter)
+	env("LDAP_GROUP_ROLES", &cfg.LDAP.GroupRoles)
+	env("LDAP_DEFAULT_ROLE", &cfg.LDAP.DefaultRole)
+	env("LDAP_STANDIN_FILE", &cfg.LDAP.StandinFile)
+	env("OIDC_ISSUER", &cfg.OIDC.Issuer)
+	env("OIDC_CLIENT_ID", &cfg.OIDC.ClientID)
+	env("OIDC_CLIENT_SECRET", &cfg.OIDC.ClientSecret)
+	env("OIDC_REDIRECT_URL", &cfg.OIDC.RedirectURL)
+	if v := os.Getenv("OIDC_SCOPES"); v != "" {
+		cfg.OIDC.Scopes = strings.Fields(v)
+	}
+	env("OIDC_ROLE_CLAIM", &cfg.OIDC.RoleClaim)
+	env("OIDC_CLAIM_ROLES", &cfg.OIDC.ClaimRoles)
+	env("OIDC_DEFAULT_ROLE", &cfg.OIDC.DefaultRole)
+	env("OIDC_POST_LOGIN_URL", &cfg.OIDC.PostLoginURL)
+	env("NOTIFY_FILE", &cfg.Notify.File)
+}
+
+// Comma separated list, like "ldap,local"
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Parse "role:name;role:name" to the role of each name
+func ParseRoleMapping(s string) (map[string]string, error) {
+	roles := map[string]string{}
+	for _, pair := range strings.Split(s, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || !model.ValidRole(strings.TrimSpace(parts[0])) || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid role mapping: %s", pair)
+		}
+		roles[strings.TrimSpace(parts[1])] = strings.TrimSpace(parts[0])
+	}
+	return roles, nil
+}
+
+// Check every setting, reporting all the problems at once
+func (cfg Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if u, err := url.Parse(cfg.Mongo.URI); err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") || u.Host == "" {
+		add("mongo.uri: must be a mongodb:// or mongodb+srv:// URI")
+	}
+	if !dbNamePattern.MatchString(cfg.Mongo.Database) {
+		add("mongo.database: must be 1 to 30 letters, digits, '_' or '-'")
+	}
+	if d, err := time.ParseDuration(cfg.Mongo.StartupTimeout); err != nil || d < 0 {
+		add("mongo.startup_timeout: must be a duration like 30s or 2m, or 0")
+	}
+	if _, port, err := net.SplitHostPort(cfg.HTTP.Address); err != nil || port == "" {
+		add("http.address: must be host:port or :port")
+	}
+	if d, err := time.ParseDuration(cfg.HTTP.ShutdownTimeout); err != nil || d <= 0 {
+		add("http.shutdown_timeout: must be a positive duration like 15s")
+	}
+	if cfg.HTTP.MetricsAddress != "" {
+		if _, port, err := net.SplitHostPort(cfg.HTTP.MetricsAddress); err != nil || port == "" {
+			add("http.metrics_address: must be host:port or :port")
+		} else if cfg.HTTP.MetricsAddress == cfg.HTTP.Address || cfg.HTTP.MetricsAddress == cfg.TLS.RedirectAddress {
+			add("http.metrics_address: must differ from http.address and tls.redirect_address")
+		}
+	}
+	for _, proxy := range cfg.HTTP.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			add("http.trusted_proxies: %s is not a CIDR like 10.0.0.0/8", proxy)
+		}
+	}
+	if (cfg.TLS.Cert == "") != (cfg.TLS.Key == "") {
+		add("tls: cert and key go together")
+	}
+	for _, f := range []struct{ name, path string }{
+		{"cert", cfg.TLS.Cert}, {"key", cfg.TLS.Key}, {"client_ca", cfg.TLS.ClientCA},
+	} {
+		if _, err := os.Stat(f.path); f.path != "" && err != nil {
+			add("tls.%s: %v", f.name, err)
+		}
+	}
+	if !cfg.TLS.Enabled() && (cfg.TLS.RedirectAddress != "" || cfg.TLS.ClientCA != "") {
+		add("tls: redirect_address and client_ca need cert and key")
+	}
+	if cfg.TLS.RedirectAddress != "" {
+		if _, port, err := net.SplitHostPort(cfg.TLS.RedirectAddress); err != nil || port == "" {
+			add("tls.redirect_address: must be host:port or :port")
+		} else if cfg.TLS.RedirectAddress == cfg.HTTP.Address {
+			add("tls.redirect_address: must differ from http.address")
+		}
+	}
+	if cfg.TLS.ClientAuth != "optional" && cfg.TLS.ClientAuth != "require" {
+		add("tls.client_auth: must be optional or require")
+	}
+	for _, origin := range cfg.Security.CORSOrigins {
+		if u, err := url.Parse(origin); origin != "*" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/")) {
+			add("security.cors_origins: %s must be scheme://host[:port] or *", origin)
+		}
+	}
+	for _, method := range cfg.Security.CORSMethods {
+		if !corsMethods[method] {
+			add("security.cors_methods: unknown method %s", method)
+		}
+	}
+	if cfg.Security.FrameOptions != "DENY" && cfg.Security.FrameOptions != "SAMEORIGIN" {
+		add("security.frame_options: must be DENY or SAMEORIGIN")
+	}
+	if cfg.Security.HSTSMaxAge < 0 {
+		add("security.hsts_max_age: must not be negative")
+	}
+	if cfg.FFmpeg.Path == "" {
+		add("ffmpeg.path: is required")
+	}
+	if !bitratePattern.MatchString(cfg.FFmpeg.Bitrate) {
+		add("ffmpeg.bitrate: must be a number with an optional k or M suffix")
+	}
+	if len(cfg.Auth.Backends) == 0 {
+		add("auth.backends: at least one is required")
+	}
+	ldap := false
+	for _, backend := range cfg.Auth.Backends {
+		switch backend {
+		case "local":
+		case "ldap":
+			ldap = true
+		default:
+			add("auth.backends: unknown backend %s", backend)
+		}
+	}
+	if ldap {
+		if cfg.LDAP.StandinFile != "" {
+			if _, err := os.Stat(cfg.LDAP.StandinFile); err != nil {
+				add("ldap.standin_file: %v", err)
+			}
+		} else if u, err := url.Parse(cfg.LDAP.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+			add("ldap.url: must be an ldap:// or ldaps:// URL for the ldap backend")
+		}
+		if _, err := ParseRoleMapping(cfg.LDAP.GroupRoles); err != nil {
+			add("ldap.group_roles: %v", err)
+		}
+		if cfg.LDAP.DefaultRole != "" && !model.ValidRole(cfg.LDAP.DefaultRole) {
+			add("ldap.default_role: unknown role %s", cfg.LDAP.DefaultRole)
+		}
+	}
+	if cfg.OIDC.Enabled() {
+		if u, err := url.Parse(cfg.OIDC.Issuer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("oidc.issuer: must be an http:// or https:// URL")
+		}
+		if cfg.OIDC.ClientID == "" {
+			add("oidc.client_id: is required with an issuer")
+		}
+		if u, err := url.Parse(cfg.OIDC.RedirectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("oidc.redirect_url: must be an http:// or https:// URL")
+		}
+		if len(cfg.OIDC.Scopes) == 0 {
+			add("oidc.scopes: at least openid is required")
+		}
+		if cfg.OIDC.RoleClaim == "" {
+			add("oidc.role_claim: is required with an issuer")
+		}
+		if _, err := ParseRoleMapping(cfg.OIDC.ClaimRoles); err != nil {
+			add("oidc.claim_roles: %v", err)
+		}
+		if cfg.OIDC.DefaultRole != "" && !model.ValidRole(cfg.OIDC.DefaultRole) {
+			add("oidc.default_role: unknown role %s", cfg.OIDC.DefaultRole)
+		}
+	}
+	if cfg.Notify.File != "" {
+		if info, err := os.Stat(filepath.Dir(cfg.Notify.File)); err != nil || !info.IsDir() {
+			add("notify.file: %s is not in a directory", cfg.Notify.File)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+}
+
+// Settings that work but are likely mistakes
+func (cfg Config) Warnings() []string {
+	var warnings []string
+	if info, err := os.Stat(cfg.HTTP.StaticDir); err != nil || !info.IsDir() {
+		warnings = append(warnings, fmt.Sprintf("http.static_dir: %s is not a directory, only the API is served", cfg.HTTP.StaticDir))
+	}
+	if cfg.Auth.JWTSecret == "" {
+		warnings = append(warnings, "auth.jwt_secret: not set, using a random secret (tokens are invalidated on restart)")
+	}
+	return warnings
+}
+
+// Copy of the configuration that can be shown, with the credentials removed
+func (cfg Config) Redacted() Config {
+	if u, err := url.Parse(cfg.Mongo.URI); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+		cfg.Mongo.URI = u.String()
+	}
+	for _, secret := range []*string{&cfg.Auth.JWTSecret, &cfg.LDAP.BindPassword, &cfg.OIDC.ClientSecret} {
+		if *secret != "" {
+			*secret = "REDACTED"
+		}
+	}
+	return cfg
+}
+
+// YAML of the redacted configuration
+func (cfg Config) String() string {
+	out, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
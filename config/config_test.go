@@ -0,0 +1,150 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDatabaseNameLength(t *testing.T) {
+	// tenant IDs have up to 32 characters, see tenantPattern in rest
+	const maxTenantID = 32
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{strings.Repeat("a", 30), true},
+		{strings.Repeat("a", 31), false},
+		{"", false},
+		{"app.prod", false},
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.Mongo.Database = tt.name
+		if err := cfg.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%q: %v, want valid %v", tt.name, err, tt.valid)
+		}
+		if tt.valid && len(tt.name)+len("_")+maxTenantID >= 64 {
+			t.Errorf("%q: tenant databases would reach 64 bytes", tt.name)
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.yaml")
+	yaml := "mongo:\n  database: fromfile\nhttp:\n  address: \":6000\"\nffmpeg:\n  bitrate: 1M\nauth:\n  backends: [ldap]\n"
+	if err := ioutil.WriteFile(file, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MONGO_DATABASE", "fromenv")
+	t.Setenv("FFMPEG_BITRATE", "3M")
+	t.Setenv("AUTH_BACKEND", "ldap, local")
+	t.Setenv("JWT_SECRET", "envsecret")
+
+	cfg, err := Load([]string{"--config", file, "--ffmpeg-bitrate", "4M", "create-admin", "erin@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		setting   string
+		got, want interface{}
+	}{
+		{"default", cfg.TLS.ClientAuth, "optional"},
+		{"file", cfg.HTTP.Address, ":6000"},
+		{"env over file", cfg.Mongo.Database, "fromenv"},
+		{"flag over env", cfg.FFmpeg.Bitrate, "4M"},
+		{"env list", cfg.Auth.Backends, []string{"ldap", "local"}},
+		{"env secret", cfg.Auth.JWTSecret, "envsecret"},
+		{"command", cfg.Args, []string{"create-admin", "erin@example.com"}},
+		{"file name", cfg.File, file},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.setting, tt.got, tt.want)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.Mongo.URI = "mongodb://app:mongopass@db:27017/?authSource=admin"
+	cfg.Auth.JWTSecret = "jwtsecret"
+	cfg.LDAP.BindPassword = "bindpass"
+	cfg.OIDC.ClientSecret = "clientsecret"
+
+	out := cfg.String()
+	for _, secret := range []string{"mongopass", "jwtsecret", "bindpass", "clientsecret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("%s printed:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "mongodb://app:REDACTED@db:27017") {
+		t.Errorf("mongo user not kept:\n%s", out)
+	}
+	if cfg.Auth.JWTSecret != "jwtsecret" {
+		t.Error("redacting changed the configuration")
+	}
+	// unset secrets stay empty, so it shows they are missing
+	if Default().Redacted().Auth.JWTSecret != "" {
+		t.Error("empty secret redacted")
+	}
+}
+
+func TestValidateSignIn(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(*Config)
+		problem string
+	}{
+		{"unknown backend", func(c *Config) { c.Auth.Backends = []string{"kerberos"} }, "auth.backends: unknown backend kerberos"},
+		{"no backend", func(c *Config) { c.Auth.Backends = nil }, "auth.backends: at least one"},
+		{"ldap without url", func(c *Config) { c.Auth.Backends = []string{"ldap"} }, "ldap.url"},
+		{"ldap role", func(c *Config) {
+			c.Auth.Backends = []string{"ldap"}
+			c.LDAP.URL = "ldaps://dc.example.com"
+			c.LDAP.GroupRoles = "owner:CN=Admins"
+		}, "ldap.group_roles"},
+		{"oidc without client", func(c *Config) { c.OIDC.Issuer = "https://id.example.com" }, "oidc.client_id"},
+		{"oidc default role", func(c *Config) {
+			c.OIDC = OIDC{Issuer: "https://id.example.com", ClientID: "app", RedirectURL: "https://app.example.com/cb",
+				Scopes: []string{"openid"}, RoleClaim: "groups", DefaultRole: "root"}
+		}, "oidc.default_role"},
+		{"trusted proxy", func(c *Config) { c.HTTP.TrustedProxies = []string{"10.0.0.1"} }, "http.trusted_proxies"},
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		tt.change(&cfg)
+		err := cfg.Validate()
+		if err == nil || !strings.Contains(err.Error(), tt.problem) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.problem)
+		}
+	}
+
+	cfg := Default()
+	cfg.Auth.Backends = []string{"ldap", "local"}
+	cfg.LDAP.URL = "ldaps://dc.example.com"
+	cfg.LDAP.GroupRoles = "admin:CN=Admins;viewer:Staff"
+	if err := cfg.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMissingStaticDirIsAWarning(t *testing.T) {
+	cfg := Default()
+	cfg.HTTP.StaticDir = filepath.Join(t.TempDir(), "missing")
+	if err := cfg.Validate(); err != nil {
+		t.Error(err)
+	}
+	warnings := strings.Join(cfg.Warnings(), "\n")
+	if !strings.Contains(warnings, "http.static_dir") {
+		t.Errorf("no static_dir warning in %q", warnings)
+	}
+}
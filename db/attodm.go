@@ -0,0 +1,79 @@
+package db
+
+import (
+	"app/model"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Failed sign-ins of an account or address, a zero attempt when there is none
+func (c *Client) GetLoginAttempt(key string) (model.LoginAttempt, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	result := model.LoginAttempt{Key: key}
+
+	attCol := c.database().Collection("loginattempt")
+	err := attCol.FindOne(ctx, bson.M{"_id": key}).Decode(&result)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Count a failed sign-in. Failures older than since are forgotten.
+// A single update, so concurrent failures all count.
+func (c *Client) RecordLoginFailure(key string, since time.Time) (model.LoginAttempt, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	result := model.LoginAttempt{Key: key}
+
+	// start again from 1 when the last failure is older than the window
+	// (or missing, for the first failure)
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"failures": bson.M{"$cond": bson.A{
+			bson.M{"$gte": bson.A{"$last", since}},
+			bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}},
+			1,
+		}},
+		"last": time.Now(),
+	}}}}
+	attCol := c.database().Collection("loginattempt")
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := attCol.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Refuse sign-ins until the given time and start counting failures again
+func (c *Client) LockLogin(key string, until time.Time) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	attCol := c.database().Collection("loginattempt")
+	_, err := attCol.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{
+		"failures": 0,
+		"locked":   until,
+	}}, options.Update().SetUpsert(true))
+
+	return err
+}
+
+// Forget the failures and lock of an account or address
+func (c *Client) ClearLoginAttempts(key string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	attCol := c.database().Collection("loginattempt")
+	_, err := attCol.DeleteOne(ctx, bson.M{"_id": key})
+
+	return err
+}
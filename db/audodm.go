@@ -0,0 +1,77 @@
+package db
+
+import (
+	"app/model"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Entries are only ever inserted, there is no update or delete
+func (c *Client) AddAudit(entry model.AuditEntry) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	audCol := c.database().Collection("audit")
+	if _, err := audCol.InsertOne(ctx, entry); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Get a page of matching entries, newest first, with the total number of matches
+func (c *Client) GetAudit(filter model.AuditFilter, page, size int64) ([]model.AuditEntry, int64, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var results []model.AuditEntry
+
+	query := bson.M{}
+	if filter.Actor != "" {
+		query["actor"] = filter.Actor
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if filter.Target != "" {
+		query["target"] = filter.Target
+	}
+	period := bson.M{}
+	if !filter.From.IsZero() {
+		period["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		period["$lt"] = filter.To
+	}
+	if len(period) > 0 {
+		query["time"] = period
+	}
+
+	audCol := c.database().Collection("audit")
+	total, err := audCol.CountDocuments(ctx, query)
+	if err != nil {
+		return results, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"time": -1}).
+		SetSkip((page - 1) * size).
+		SetLimit(size)
+	cursor, err := audCol.Find(ctx, query, opts)
+	if err != nil {
+		return results, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var result model.AuditEntry
+		if err := cursor.Decode(&result); err != nil {
+			return results, 0, err
+		}
+		results = append(results, result)
+	}
+
+	return results, total, nil
+}
@@ -15,7 +15,7 @@ func (c *Client) GetAllCam() ([]model.Camera, error) {
 
 	var results []model.Camera
 
-	camCol := c.Client.Database(DatabaseName).Collection("camera")
+	camCol := c.database().Collection("camera")
 	cursor, err := camCol.Find(ctx, bson.M{})
 	if err != nil {
 		return results, err
@@ -38,7 +38,7 @@ func (c *Client) AddNewCam(cam model.Camera) error {
 	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancle()
 
-	camCol := c.Client.Database(DatabaseName).Collection("camera")
+	camCol := c.database().Collection("camera")
 	err := camCol.FindOne(ctx, bson.M{"name": cam.Name}).Decode(&model.Camera{})
 	if err == nil { // existed
 		return ErrINVALIDDATA
@@ -63,7 +63,7 @@ func (c *Client) GetCamByID(name string) (model.Camera, error) {
 
 	var result model.Camera
 
-	camCol := c.Client.Database(DatabaseName).Collection("camera")
+	camCol := c.database().Collection("camera")
 	if err := camCol.FindOne(ctx, bson.M{"name": name}).Decode(&result); err != nil {
 		return result, err
 	}
@@ -76,11 +76,42 @@ func (c *Client) DeleteCam(name string) error {
 	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancle()
 
-	camCol := c.Client.Database(DatabaseName).Collection("camera")
+	camCol := c.database().Collection("camera")
 	result, _ := camCol.DeleteOne(ctx, bson.M{"name": name})
 	if result.DeletedCount == 0 {
 		return ErrINVALIDDATA
 	}
 
+	return c.forgetCamRefs(ctx, name)
+}
+
+// Replace the camera, keeping names unique when it is renamed
+func (c *Client) UpdateCam(name string, cam model.Camera) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	camCol := c.database().Collection("camera")
+	if cam.Name != name {
+		err := camCol.FindOne(ctx, bson.M{"name": cam.Name}).Decode(&model.Camera{})
+		if err == nil { // existed
+			return ErrINVALIDDATA
+		}
+	}
+
+	result, err := camCol.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$set": bson.M{
+		"name":  cam.Name,
+		"rtsp":  cam.Rtsp,
+		"codec": cam.Codec,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+	if cam.Name != name {
+		return c.renameCamRefs(ctx, name, cam.Name)
+	}
+
 	return nil
 }
@@ -1,12 +1,17 @@
 package db
 
 import (
+	"app/config"
+	"app/metrics"
 	"app/model"
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -14,39 +19,199 @@ import (
 var (
 	ErrINVALIDPASSWORD = errors.New("Invalid password")
 	ErrINVALIDDATA     = errors.New("Already associated")
-
-	DatabaseName = "testApp"
+	ErrNOTFOUND        = errors.New("Not found")
+	ErrDISABLED        = errors.New("Account disabled")
+	ErrSOURCE          = errors.New("Account managed by another sign-in method")
 )
 
 type DBInterface interface {
+	// connection
+	Ping() error
+	WaitForServer(time.Duration) error
+	Disconnect(context.Context) error
+	CreateIndexes() error
+	// tenants
+	ForTenant(string) DBInterface
+	Tenant() string
+	CreateTenant(model.Tenant) error
+	DeleteTenant(string) error
+	GetTenants() ([]model.Tenant, error)
+	GetTenant(string) (model.Tenant, error)
 	// user
 	UserSignIn(string, string) (model.User, error)
 	UserSignUp(model.User) error
+	UpdateUserRoles(string, []string) error
+	GetUserByEmail(string) (model.User, error)
+	ProvisionUser(model.User) (model.User, error)
+	GetUsers(int64, int64) ([]model.User, int64, error)
+	UpdateUser(string, model.UserUpdate) error
+	SetUserDisabled(string, bool) error
+	DeleteUser(string) error
+	ChangePassword(string, string, string) error
+	CreatePasswordReset(model.PasswordReset) error
+	ConsumePasswordReset(string) (model.PasswordReset, error)
+	// two-factor authentication
+	SetTOTPSecret(string, string) error
+	EnableTOTP(string, []string) error
+	ResetTOTP(string) error
+	UseRecoveryCode(string, string) error
+	UseTOTPStep(string, int64) error
+	UseMFAToken(string, time.Time) error
+	// api keys
+	CreateAPIKey(model.APIKey) error
+	GetAPIKeys(string) ([]model.APIKey, error)
+	UseAPIKey(string) (model.APIKey, error)
+	RevokeAPIKey(string, string) error
+	// sign-in attempts
+	GetLoginAttempt(string) (model.LoginAttempt, error)
+	RecordLoginFailure(string, time.Time) (model.LoginAttempt, error)
+	LockLogin(string, time.Time) error
+	ClearLoginAttempts(string) error
+	// sessions
+	CreateSession(model.Session) error
+	GetSession(string) (model.Session, error)
+	RotateSession(string, string) (model.Session, error)
+	RevokeSession(string) error
+	RevokeUserSessions(string) ([]string, error)
+	// audit
+	AddAudit(model.AuditEntry) error
+	GetAudit(model.AuditFilter, int64, int64) ([]model.AuditEntry, int64, error)
 	// cams
 	GetAllCam() ([]model.Camera, error)
 	AddNewCam(model.Camera) error
 	GetCamByID(string) (model.Camera, error)
 	DeleteCam(string) error
+	UpdateCam(string, model.Camera) error
 	// server
 	GetAllServer() ([]model.OpcUAServer, error)
 	AddNewServer(model.OpcUAServer) error
 	GetServerByID(string) (model.OpcUAServer, error)
 	DeleteServer(string) error
+	UpdateServer(string, model.OpcUAServer) error
+	AddServerNode(string, string) error
+	RemoveServerNode(string, string) error
+	// gates
+	GetAllGates() ([]model.Gate, error)
+	AddNewGate(model.Gate) error
+	GetGateByID(string) (model.Gate, error)
+	DeleteGate(string) error
+	UpdateGate(string, model.Gate) error
+	AddGateCam(string, string) error
+	RemoveGateCam(string, string) error
+	SetGateServer(string, model.GateServer) error
+	RemoveGateServer(string, string) error
+	// machines
+	GetAllMachines() ([]model.Machine, error)
+	AddNewMachine(model.Machine) error
+	GetMachineByID(string) (model.Machine, error)
+	DeleteMachine(string) error
+	UpdateMachine(string, model.Machine) error
+	// locations
+	GetAllLocations() ([]model.Location, error)
+	AddNewLocation(model.Location) error
+	GetLocationByID(string) (model.Location, error)
+	UpdateLocation(string, model.Location) error
+	DeleteLocation(string) error
 }
 
 type Client struct {
 	*mongo.Client
+	name   string // database of the default tenant, the other tenants' have it as prefix
+	tenant string // empty for the default tenant
 }
 
-func NewClient() (*Client, error) {
+func NewClient(cfg config.Mongo) (*Client, error) {
 	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancle()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.URI).SetMonitor(commandMonitor()))
 	if err != nil {
 		fmt.Print(err)
 		return &Client{}, fmt.Errorf("DB Connection err:%v", err)
 	}
 
-	return &Client{client}, nil
+	return &Client{Client: client, name: cfg.Database}, nil
+}
+
+// Record the latency of every command sent to the server
+func commandMonitor() *event.CommandMonitor {
+	observe := func(command, status string, nanos int64) {
+		metrics.MongoCommandDuration.WithLabelValues(command, status).Observe(time.Duration(nanos).Seconds())
+	}
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			observe(e.CommandName, "success", e.DurationNanos)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			observe(e.CommandName, "failure", e.DurationNanos)
+		},
+	}
+}
+
+// Check the server answers
+func (c *Client) Ping() error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	return c.Client.Ping(ctx, nil)
+}
+
+// Ping the server until it answers, waiting twice as long after each failure
+// up to a minute. Gives up after timeout, or retries forever when it is 0.
+func (c *Client) WaitForServer(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		err := c.Ping()
+		if err == nil {
+			return nil
+		}
+		// the last wait is cut short to make a last attempt at the deadline
+		sleep := wait
+		if timeout > 0 {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return fmt.Errorf("MongoDB unreachable after %d attempts: %v", attempt, err)
+			}
+			if sleep > remaining {
+				sleep = remaining
+			}
+		}
+		log.Printf("MongoDB unreachable (attempt %d), retrying in %s: %v", attempt, sleep.Round(time.Millisecond), err)
+		time.Sleep(sleep)
+		if wait *= 2; wait > time.Minute {
+			wait = time.Minute
+		}
+	}
+}
+
+// Same connection, working on the data of another tenant
+func (c *Client) ForTenant(tenant string) DBInterface {
+	return &Client{Client: c.Client, name: c.name, tenant: tenant}
+}
+
+func (c *Client) Tenant() string {
+	return c.tenant
+}
+
+// Database of the tenant. The default tenant keeps the data from before tenants.
+func (c *Client) database() *mongo.Database {
+	if c.tenant == "" {
+		return c.Client.Database(c.name)
+	}
+	return c.Client.Database(c.name + "_" + c.tenant)
+}
+
+// Database shared by every tenant for tenants, sessions, API keys and reset
+// tokens, which record their tenant
+func (c *Client) shared() *mongo.Database {
+	return c.Client.Database(c.name)
+}
+
+// Filter value matching the records of the tenant, the default tenant's have no tenant field
+func (c *Client) tenantFilter() interface{} {
+	if c.tenant == "" {
+		return bson.M{"$in": bson.A{"", nil}}
+	}
+	return c.tenant
 }
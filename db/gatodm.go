@@ -0,0 +1,254 @@
+package db
+
+import (
+	"app/model"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (c *Client) GetAllGates() ([]model.Gate, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var results []model.Gate
+
+	gateCol := c.database().Collection("gate")
+	cursor, err := gateCol.Find(ctx, bson.M{})
+	if err != nil {
+		return results, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var result model.Gate
+		if err := cursor.Decode(&result); err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (c *Client) AddNewGate(gate model.Gate) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	gateCol := c.database().Collection("gate")
+	err := gateCol.FindOne(ctx, bson.M{"name": gate.Name}).Decode(&model.Gate{})
+	if err == nil { // existed
+		return ErrINVALIDDATA
+	}
+
+	_, err = gateCol.InsertOne(ctx, gateDoc(gate))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) GetGateByID(name string) (model.Gate, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var result model.Gate
+
+	gateCol := c.database().Collection("gate")
+	if err := gateCol.FindOne(ctx, bson.M{"name": name}).Decode(&result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (c *Client) DeleteGate(name string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	gateCol := c.database().Collection("gate")
+	result, err := gateCol.DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrINVALIDDATA
+	}
+
+	return nil
+}
+
+// Replace the gate, keeping names unique when it is renamed
+func (c *Client) UpdateGate(name string, gate model.Gate) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	gateCol := c.database().Collection("gate")
+	if gate.Name != name {
+		err := gateCol.FindOne(ctx, bson.M{"name": gate.Name}).Decode(&model.Gate{})
+		if err == nil { // existed
+			return ErrINVALIDDATA
+		}
+	}
+
+	result, err := gateCol.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$set": gateDoc(gate)})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Attach a camera to the gate, nothing changes when it is already attached
+func (c *Client) AddGateCam(name, cam string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	gateCol := c.database().Collection("gate")
+	result, err := gateCol.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$addToSet": bson.M{"cameras": cam}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Detach a camera from the gate
+func (c *Client) RemoveGateCam(name, cam string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	gateCol := c.database().Collection("gate")
+	result, err := gateCol.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$pull": bson.M{"cameras": cam}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 { // no such gate or camera
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Attach a server to the gate, or change the nodes of an attached server
+func (c *Client) SetGateServer(name string, server model.GateServer) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	if server.NodeID == nil {
+		server.NodeID = []string{}
+	}
+
+	gateCol := c.database().Collection("gate")
+	result, err := gateCol.UpdateOne(ctx,
+		bson.M{"name": name, "servers.server": server.Server},
+		bson.M{"$set": bson.M{"servers.$.nodeid": server.NodeID}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	result, err = gateCol.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$push": bson.M{"servers": server}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Detach a server from the gate
+func (c *Client) RemoveGateServer(name, server string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	gateCol := c.database().Collection("gate")
+	result, err := gateCol.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$pull": bson.M{"servers": bson.M{"server": server}}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 { // no such gate or server
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Follow the rename of a camera in the gates and machines referring to it
+func (c *Client) renameCamRefs(ctx context.Context, name, newName string) error {
+	for _, col := range []string{"gate", "machine"} {
+		refCol := c.database().Collection(col)
+		if _, err := refCol.UpdateMany(ctx, bson.M{"cameras": name}, bson.M{"$set": bson.M{"cameras.$": newName}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Detach a deleted camera from every gate and machine
+func (c *Client) forgetCamRefs(ctx context.Context, name string) error {
+	for _, col := range []string{"gate", "machine"} {
+		refCol := c.database().Collection(col)
+		if _, err := refCol.UpdateMany(ctx, bson.M{"cameras": name}, bson.M{"$pull": bson.M{"cameras": name}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Follow the rename of a server in the gates and machines referring to it
+func (c *Client) renameServerRefs(ctx context.Context, name, newName string) error {
+	gateCol := c.database().Collection("gate")
+	if _, err := gateCol.UpdateMany(ctx, bson.M{"servers.server": name}, bson.M{"$set": bson.M{"servers.$.server": newName}}); err != nil {
+		return err
+	}
+	macCol := c.database().Collection("machine")
+	_, err := macCol.UpdateMany(ctx, bson.M{"server": name}, bson.M{"$set": bson.M{"server": newName}})
+	return err
+}
+
+// Detach a deleted server from every gate and machine
+func (c *Client) forgetServerRefs(ctx context.Context, name string) error {
+	gateCol := c.database().Collection("gate")
+	if _, err := gateCol.UpdateMany(ctx, bson.M{"servers.server": name}, bson.M{"$pull": bson.M{"servers": bson.M{"server": name}}}); err != nil {
+		return err
+	}
+	macCol := c.database().Collection("machine")
+	_, err := macCol.UpdateMany(ctx, bson.M{"server": name}, bson.M{"$set": bson.M{"server": "", "nodeid": []string{}}})
+	return err
+}
+
+// Stored fields of a gate, with empty lists rather than null so they can be added to
+func gateDoc(gate model.Gate) bson.D {
+	if gate.Machine == nil {
+		gate.Machine = []string{}
+	}
+	if gate.Cameras == nil {
+		gate.Cameras = []string{}
+	}
+	servers := []model.GateServer{}
+	for _, s := range gate.Servers {
+		if s.NodeID == nil {
+			s.NodeID = []string{}
+		}
+		servers = append(servers, s)
+	}
+	return bson.D{
+		{Key: "name", Value: gate.Name},
+		{Key: "location", Value: gate.Location},
+		{Key: "machine", Value: gate.Machine},
+		{Key: "cameras", Value: gate.Cameras},
+		{Key: "servers", Value: servers},
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"app/model"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (c *Client) CreateAPIKey(key model.APIKey) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	keyCol := c.shared().Collection("apikey")
+	doc := bson.D{
+		{Key: "_id", Value: key.ID},
+		{Key: "name", Value: key.Name},
+		{Key: "email", Value: key.Email},
+		{Key: "hash", Value: key.Hash},
+		{Key: "scope", Value: key.Scope},
+		{Key: "created", Value: key.CreatedAt},
+		{Key: "revoked", Value: false},
+		{Key: "tenant", Value: c.tenant},
+	}
+	if key.ExpiresAt != nil {
+		doc = append(doc, bson.E{Key: "expires", Value: *key.ExpiresAt})
+	}
+
+	if _, err := keyCol.InsertOne(ctx, doc); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Get the keys of a user, or of every user of the tenant when email is empty
+func (c *Client) GetAPIKeys(email string) ([]model.APIKey, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var results []model.APIKey
+
+	filter := bson.M{"tenant": c.tenantFilter()}
+	if email != "" {
+		filter["email"] = email
+	}
+
+	keyCol := c.shared().Collection("apikey")
+	cursor, err := keyCol.Find(ctx, filter, options.Find().SetSort(bson.M{"created": 1}))
+	if err != nil {
+		return results, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var result model.APIKey
+		if err := cursor.Decode(&result); err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Find an active key by hash and record it was used
+func (c *Client) UseAPIKey(hash string) (model.APIKey, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var result model.APIKey
+
+	keyCol := c.shared().Collection("apikey")
+	filter := bson.M{"hash": hash, "revoked": false}
+	update := bson.M{"$set": bson.M{"last_used": time.Now()}}
+	if err := keyCol.FindOneAndUpdate(ctx, filter, update).Decode(&result); err != nil {
+		return result, ErrNOTFOUND
+	}
+	if result.ExpiresAt != nil && result.ExpiresAt.Before(time.Now()) {
+		return result, ErrNOTFOUND
+	}
+
+	return result, nil
+}
+
+// Revoke a key of a user, or of any user of the tenant when email is empty
+func (c *Client) RevokeAPIKey(id, email string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	filter := bson.M{"_id": id, "tenant": c.tenantFilter()}
+	if email != "" {
+		filter["email"] = email
+	}
+
+	keyCol := c.shared().Collection("apikey")
+	result, err := keyCol.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
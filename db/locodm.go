@@ -0,0 +1,122 @@
+package db
+
+import (
+	"app/model"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Every site and area, sites first
+func (c *Client) GetAllLocations() ([]model.Location, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var results []model.Location
+
+	locCol := c.database().Collection("location")
+	opts := options.Find().SetSort(bson.D{{Key: "kind", Value: -1}, {Key: "id", Value: 1}})
+	cursor, err := locCol.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return results, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var result model.Location
+		if err := cursor.Decode(&result); err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (c *Client) AddNewLocation(loc model.Location) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	locCol := c.database().Collection("location")
+	err := locCol.FindOne(ctx, bson.M{"id": loc.ID}).Decode(&model.Location{})
+	if err == nil { // existed
+		return ErrINVALIDDATA
+	}
+
+	_, err = locCol.InsertOne(ctx, bson.D{
+		{Key: "id", Value: loc.ID},
+		{Key: "name", Value: loc.Name},
+		{Key: "kind", Value: loc.Kind},
+		{Key: "parent", Value: loc.Parent},
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) GetLocationByID(id string) (model.Location, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var result model.Location
+
+	locCol := c.database().Collection("location")
+	if err := locCol.FindOne(ctx, bson.M{"id": id}).Decode(&result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Change the name and parent of the location, its ID and kind stay
+func (c *Client) UpdateLocation(id string, loc model.Location) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	locCol := c.database().Collection("location")
+	result, err := locCol.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": bson.M{
+		"name":   loc.Name,
+		"parent": loc.Parent,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Delete the location, only when no area or gate is under it
+func (c *Client) DeleteLocation(id string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	locCol := c.database().Collection("location")
+	gateCol := c.database().Collection("gate")
+	areas, err := locCol.CountDocuments(ctx, bson.M{"parent": id})
+	if err != nil {
+		return err
+	}
+	gates, err := gateCol.CountDocuments(ctx, bson.M{"location": id})
+	if err != nil {
+		return err
+	}
+	if areas > 0 || gates > 0 {
+		return ErrINVALIDDATA
+	}
+
+	result, err := locCol.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"app/model"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (c *Client) GetAllMachines() ([]model.Machine, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var results []model.Machine
+
+	macCol := c.database().Collection("machine")
+	cursor, err := macCol.Find(ctx, bson.M{})
+	if err != nil {
+		return results, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var result model.Machine
+		if err := cursor.Decode(&result); err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (c *Client) AddNewMachine(machine model.Machine) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	macCol := c.database().Collection("machine")
+	err := macCol.FindOne(ctx, bson.M{"id": machine.ID}).Decode(&model.Machine{})
+	if err == nil { // existed
+		return ErrINVALIDDATA
+	}
+
+	_, err = macCol.InsertOne(ctx, machineDoc(machine))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) GetMachineByID(id string) (model.Machine, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var result model.Machine
+
+	macCol := c.database().Collection("machine")
+	if err := macCol.FindOne(ctx, bson.M{"id": id}).Decode(&result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Delete the machine and remove it from the gates
+func (c *Client) DeleteMachine(id string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	macCol := c.database().Collection("machine")
+	result, err := macCol.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrINVALIDDATA
+	}
+
+	gateCol := c.database().Collection("gate")
+	_, err = gateCol.UpdateMany(ctx, bson.M{"machine": id}, bson.M{"$pull": bson.M{"machine": id}})
+	return err
+}
+
+// Replace the machine, keeping IDs unique when it is renamed
+func (c *Client) UpdateMachine(id string, machine model.Machine) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	macCol := c.database().Collection("machine")
+	if machine.ID != id {
+		err := macCol.FindOne(ctx, bson.M{"id": machine.ID}).Decode(&model.Machine{})
+		if err == nil { // existed
+			return ErrINVALIDDATA
+		}
+	}
+
+	result, err := macCol.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": machineDoc(machine)})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+	if machine.ID != id {
+		gateCol := c.database().Collection("gate")
+		_, err := gateCol.UpdateMany(ctx, bson.M{"machine": id}, bson.M{"$set": bson.M{"machine.$": machine.ID}})
+		return err
+	}
+
+	return nil
+}
+
+// Stored fields of a machine, with empty lists rather than null
+func machineDoc(machine model.Machine) bson.D {
+	if machine.NodeID == nil {
+		machine.NodeID = []string{}
+	}
+	if machine.Cameras == nil {
+		machine.Cameras = []string{}
+	}
+	return bson.D{
+		{Key: "id", Value: machine.ID},
+		{Key: "description", Value: machine.Description},
+		{Key: "asset", Value: machine.Asset},
+		{Key: "server", Value: machine.Server},
+		{Key: "nodeid", Value: machine.NodeID},
+		{Key: "cameras", Value: machine.Cameras},
+	}
+}
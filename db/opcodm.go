@@ -14,7 +14,7 @@ func (c *Client) GetAllServer() ([]model.OpcUAServer, error) {
 
 	var results []model.OpcUAServer
 
-	opcCol := c.Client.Database(DatabaseName).Collection("opcua")
+	opcCol := c.database().Collection("opcua")
 	cursor, err := opcCol.Find(ctx, bson.M{})
 	if err != nil {
 		return results, err
@@ -36,7 +36,7 @@ func (c *Client) AddNewServer(server model.OpcUAServer) error {
 	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancle()
 
-	opcCol := c.Client.Database(DatabaseName).Collection("opcua")
+	opcCol := c.database().Collection("opcua")
 	err := opcCol.FindOne(ctx, bson.M{"name": server.Name}).Decode(&model.OpcUAServer{})
 	if err == nil { // existed
 		return ErrINVALIDDATA
@@ -65,7 +65,7 @@ func (c *Client) GetServerByID(name string) (model.OpcUAServer, error) {
 
 	var result model.OpcUAServer
 
-	opcCol := c.Client.Database(DatabaseName).Collection("opcua")
+	opcCol := c.database().Collection("opcua")
 	if err := opcCol.FindOne(ctx, bson.M{"name": name}).Decode(&result); err != nil {
 		return result, err
 	}
@@ -78,11 +78,80 @@ func (c *Client) DeleteServer(name string) error {
 	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancle()
 
-	opcCol := c.Client.Database(DatabaseName).Collection("opcua")
+	opcCol := c.database().Collection("opcua")
 	result, _ := opcCol.DeleteOne(ctx, bson.M{"name": name})
 	if result.DeletedCount == 0 {
 		return ErrINVALIDDATA
 	}
 
+	return c.forgetServerRefs(ctx, name)
+}
+
+// Replace the server, which may be renamed to a free name
+func (c *Client) UpdateServer(name string, server model.OpcUAServer) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	opcCol := c.database().Collection("opcua")
+	if server.Name != name {
+		err := opcCol.FindOne(ctx, bson.M{"name": server.Name}).Decode(&model.OpcUAServer{})
+		if err == nil { // existed
+			return ErrINVALIDDATA
+		}
+	}
+
+	result, err := opcCol.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$set": bson.M{
+		"name":     server.Name,
+		"endpoint": server.Endpoint,
+		"policy":   server.Policy,
+		"mode":     server.Mode,
+		"cert":     server.Cert,
+		"key":      server.Key,
+		"nodeid":   server.NodeID,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+	if server.Name != name {
+		return c.renameServerRefs(ctx, name, server.Name)
+	}
+
+	return nil
+}
+
+// Add a node to the server, nothing changes when it is already there
+func (c *Client) AddServerNode(name, node string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	opcCol := c.database().Collection("opcua")
+	result, err := opcCol.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$addToSet": bson.M{"nodeid": node}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Remove a node from the server
+func (c *Client) RemoveServerNode(name, node string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	opcCol := c.database().Collection("opcua")
+	result, err := opcCol.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$pull": bson.M{"nodeid": node}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 { // no such server or node
+		return ErrNOTFOUND
+	}
+
 	return nil
 }
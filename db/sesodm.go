@@ -0,0 +1,117 @@
+package db
+
+import (
+	"app/model"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (c *Client) CreateSession(session model.Session) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	sesCol := c.shared().Collection("session")
+	_, err := sesCol.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: session.ID},
+		{Key: "email", Value: session.Email},
+		{Key: "refresh", Value: session.Refresh},
+		{Key: "created", Value: session.CreatedAt},
+		{Key: "expires", Value: session.ExpiresAt},
+		{Key: "revoked", Value: false},
+		{Key: "mfa", Value: session.MFA},
+		{Key: "tenant", Value: c.tenant},
+	})
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Get a session by id, revoked and expired ones included
+func (c *Client) GetSession(id string) (model.Session, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var result model.Session
+
+	sesCol := c.shared().Collection("session")
+	if err := sesCol.FindOne(ctx, bson.M{"_id": id}).Decode(&result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Replace the refresh token of an active session. The old token can't be used again.
+func (c *Client) RotateSession(refresh, newRefresh string) (model.Session, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var result model.Session
+
+	sesCol := c.shared().Collection("session")
+	filter := bson.M{
+		"refresh": refresh,
+		"revoked": false,
+		"expires": bson.M{"$gt": time.Now()},
+	}
+	err := sesCol.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"refresh": newRefresh}}).Decode(&result)
+	if err != nil {
+		return result, ErrNOTFOUND
+	}
+
+	return result, nil
+}
+
+// Revoke a session, its refresh token can't be used anymore
+func (c *Client) RevokeSession(id string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	sesCol := c.shared().Collection("session")
+	result, err := sesCol.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Revoke every active session of a user and return their ids
+func (c *Client) RevokeUserSessions(email string) ([]string, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var ids []string
+
+	sesCol := c.shared().Collection("session")
+	filter := bson.M{"email": email, "tenant": c.tenantFilter(), "revoked": false}
+	cursor, err := sesCol.Find(ctx, filter)
+	if err != nil {
+		return ids, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var result model.Session
+		if err := cursor.Decode(&result); err != nil {
+			return ids, err
+		}
+		ids = append(ids, result.ID)
+	}
+
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	if _, err := sesCol.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$set": bson.M{"revoked": true}}); err != nil {
+		return ids, err
+	}
+
+	return ids, nil
+}
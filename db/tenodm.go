@@ -0,0 +1,90 @@
+package db
+
+import (
+	"app/model"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (c *Client) CreateTenant(tenant model.Tenant) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	tenCol := c.shared().Collection("tenant")
+	err := tenCol.FindOne(ctx, bson.M{"_id": tenant.ID}).Decode(&model.Tenant{})
+	if err == nil { // existed
+		return ErrINVALIDDATA
+	}
+
+	_, err = tenCol.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: tenant.ID},
+		{Key: "name", Value: tenant.Name},
+		{Key: "created", Value: tenant.CreatedAt},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrINVALIDDATA
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) GetTenants() ([]model.Tenant, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var results []model.Tenant
+
+	tenCol := c.shared().Collection("tenant")
+	cursor, err := tenCol.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return results, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var result model.Tenant
+		if err := cursor.Decode(&result); err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (c *Client) GetTenant(id string) (model.Tenant, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var result model.Tenant
+
+	tenCol := c.shared().Collection("tenant")
+	if err := tenCol.FindOne(ctx, bson.M{"_id": id}).Decode(&result); err != nil {
+		return result, ErrNOTFOUND
+	}
+
+	return result, nil
+}
+
+// Delete a tenant and drop its database, never the default tenant's
+func (c *Client) DeleteTenant(id string) error {
+	if id == "" {
+		return ErrINVALIDDATA
+	}
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	tenCol := c.shared().Collection("tenant")
+	if _, err := tenCol.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return err
+	}
+
+	tenant := &Client{Client: c.Client, name: c.name, tenant: id}
+	return tenant.database().Drop(ctx)
+}
@@ -7,36 +7,57 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// bcrypt hash compared against when the account doesn't exist
+var dummyHash = func() string {
+	h, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return string(h)
+}()
+
 func (c *Client) UserSignIn(email, password string) (model.User, error) {
 	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancle()
 
 	var dbUser model.User
-	// check email
-	userCol := c.Client.Database(DatabaseName).Collection("user")
+	// check email. Unknown accounts fail like wrong passwords, after the same
+	// amount of work, so they can't be told apart.
+	userCol := c.database().Collection("user")
 	if err := userCol.FindOne(ctx, bson.M{"email": email}).Decode(&dbUser); err != nil {
+		if err == mongo.ErrNoDocuments {
+			checkPassword(dummyHash, password)
+			return dbUser, ErrINVALIDPASSWORD
+		}
 		return dbUser, err
 	}
 
 	if !checkPassword(dbUser.Password, password) {
 		return dbUser, ErrINVALIDPASSWORD
 	}
+	if dbUser.Disabled {
+		return dbUser, ErrDISABLED
+	}
 	dbUser.Password = ""
+	if len(dbUser.Roles) == 0 { // users created before roles existed
+		dbUser.Roles = []string{model.RoleViewer}
+	}
 
 	return dbUser, nil
 
 }
 
+// Add a local user with the roles and sites of user, viewer when it has no role.
+// The unique e-mail index refuses concurrent sign-ups of the same account.
 func (c *Client) UserSignUp(user model.User) error {
 	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancle()
 
 	var dbUser model.User
 	// Check email(unique)
-	userCol := c.Client.Database(DatabaseName).Collection("user")
+	userCol := c.database().Collection("user")
 	err := userCol.FindOne(ctx, bson.M{"email": user.Email}).Decode(&dbUser)
 	if err == nil { // email has existed
 		return ErrINVALIDDATA
@@ -46,20 +67,441 @@ func (c *Client) UserSignUp(user model.User) error {
 		return err
 	}
 
-	_, err = userCol.InsertOne(ctx, bson.D{
+	roles := user.Roles
+	if len(roles) == 0 {
+		roles = []string{model.RoleViewer}
+	}
+
+	doc := bson.D{
 		{Key: "firstname", Value: user.FirstName},
 		{Key: "lastname", Value: user.LastName},
 		{Key: "email", Value: user.Email},
 		{Key: "password", Value: user.Password},
+		{Key: "roles", Value: roles},
+		{Key: "disabled", Value: false},
+	}
+	if len(user.Sites) > 0 {
+		doc = append(doc, bson.E{Key: "sites", Value: user.Sites})
+	}
+	_, err = userCol.InsertOne(ctx, doc)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrINVALIDDATA
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Create the indexes of the tenant's collections, e-mails are unique.
+// The default tenant also gets those of the shared collections.
+func (c *Client) CreateIndexes() error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	userCol := c.database().Collection("user")
+	if _, err := userCol.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+
+	if c.tenant != "" {
+		return nil
+	}
+	// used MFA tokens are only kept until they expire
+	mfaCol := c.shared().Collection("mfatoken")
+	_, err := mfaCol.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	return err
+}
+
+func (c *Client) GetUserByEmail(email string) (model.User, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var result model.User
+
+	userCol := c.database().Collection("user")
+	if err := userCol.FindOne(ctx, bson.M{"email": email}).Decode(&result); err != nil {
+		return result, err
+	}
+	result.Password = ""
+	if len(result.Roles) == 0 {
+		result.Roles = []string{model.RoleViewer}
+	}
+
+	return result, nil
+}
+
+// Create or update a user signed in through an external directory.
+// Names and roles follow the directory, the rest is kept. Accounts of the
+// same e-mail created locally or by another directory are refused.
+func (c *Client) ProvisionUser(user model.User) (model.User, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var result model.User
+
+	userCol := c.database().Collection("user")
+	if err := userCol.FindOne(ctx, bson.M{"email": user.Email, "source": bson.M{"$ne": user.Source}}).Err(); err == nil {
+		return result, ErrSOURCE
+	} else if err != mongo.ErrNoDocuments {
+		return result, err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"firstname": user.FirstName,
+			"lastname":  user.LastName,
+			"roles":     user.Roles,
+			"source":    user.Source,
+		},
+		"$setOnInsert": bson.M{
+			"email":    user.Email,
+			"disabled": false,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := userCol.FindOneAndUpdate(ctx, bson.M{"email": user.Email, "source": user.Source}, update, opts).Decode(&result); err != nil {
+		return result, err
+	}
+	result.Password = ""
+
+	return result, nil
+}
+
+func (c *Client) UpdateUserRoles(email string, roles []string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	userCol := c.database().Collection("user")
+	result, err := userCol.UpdateOne(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{"roles": roles}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Get a page of users sorted by email, with the total number of users
+func (c *Client) GetUsers(page, size int64) ([]model.User, int64, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var results []model.User
+
+	userCol := c.database().Collection("user")
+	total, err := userCol.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return results, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"email": 1}).
+		SetSkip((page - 1) * size).
+		SetLimit(size).
+		SetProjection(bson.M{"password": 0, "totp_secret": 0, "recovery_codes": 0, "totp_step": 0})
+	cursor, err := userCol.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return results, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var result model.User
+		if err := cursor.Decode(&result); err != nil {
+			return results, 0, err
+		}
+		results = append(results, result)
+	}
+
+	return results, total, nil
+}
+
+func (c *Client) UpdateUser(email string, update model.UserUpdate) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	set := bson.M{}
+	if update.FirstName != nil {
+		set["firstname"] = *update.FirstName
+	}
+	if update.LastName != nil {
+		set["lastname"] = *update.LastName
+	}
+	if update.Password != nil {
+		password := *update.Password
+		if err := hashPassword(&password); err != nil {
+			return err
+		}
+		set["password"] = password
+	}
+	if update.Roles != nil {
+		set["roles"] = update.Roles
+	}
+	if update.Sites != nil {
+		set["sites"] = update.Sites
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	userCol := c.database().Collection("user")
+	result, err := userCol.UpdateOne(ctx, bson.M{"email": email}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Disabled users can't sign in anymore
+func (c *Client) SetUserDisabled(email string, disabled bool) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	userCol := c.database().Collection("user")
+	result, err := userCol.UpdateOne(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{"disabled": disabled}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+func (c *Client) DeleteUser(email string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	userCol := c.database().Collection("user")
+	result, err := userCol.DeleteOne(ctx, bson.M{"email": email})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Change the password of a user after checking the current one
+func (c *Client) ChangePassword(email, oldPassword, newPassword string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var dbUser model.User
+	userCol := c.database().Collection("user")
+	if err := userCol.FindOne(ctx, bson.M{"email": email}).Decode(&dbUser); err != nil {
+		return ErrNOTFOUND
+	}
+
+	if !checkPassword(dbUser.Password, oldPassword) {
+		return ErrINVALIDPASSWORD
+	}
+
+	if err := hashPassword(&newPassword); err != nil {
+		return err
+	}
+	_, err := userCol.UpdateOne(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{"password": newPassword}})
+	return err
+}
+
+func (c *Client) CreatePasswordReset(reset model.PasswordReset) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	resetCol := c.shared().Collection("reset")
+	_, err := resetCol.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: reset.Token},
+		{Key: "email", Value: reset.Email},
+		{Key: "expires", Value: reset.ExpiresAt},
+		{Key: "used", Value: false},
+		{Key: "tenant", Value: c.tenant},
 	})
 
 	if err != nil {
 		return err
 	}
+	return nil
+}
+
+// Mark an unused, unexpired reset token as used and return it
+func (c *Client) ConsumePasswordReset(token string) (model.PasswordReset, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var result model.PasswordReset
+
+	resetCol := c.shared().Collection("reset")
+	filter := bson.M{
+		"_id":     token,
+		"used":    false,
+		"expires": bson.M{"$gt": time.Now()},
+	}
+	if err := resetCol.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"used": true}}).Decode(&result); err != nil {
+		return result, ErrNOTFOUND
+	}
+
+	return result, nil
+}
+
+// Store a new TOTP secret, not used until EnableTOTP
+func (c *Client) SetTOTPSecret(email, secret string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	userCol := c.database().Collection("user")
+	result, err := userCol.UpdateOne(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{
+		"totp_secret":    secret,
+		"totp_enabled":   false,
+		"recovery_codes": []string{},
+		"totp_step":      0,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
 
 	return nil
 }
 
+// Require the TOTP code at sign-in, with recovery codes for a lost device
+func (c *Client) EnableTOTP(email string, recoveryCodes []string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	hashes := make([]string, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i] = code
+		if err := hashPassword(&hashes[i]); err != nil {
+			return err
+		}
+	}
+
+	userCol := c.database().Collection("user")
+	filter := bson.M{"email": email, "totp_secret": bson.M{"$nin": []interface{}{"", nil}}}
+	result, err := userCol.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"totp_enabled":   true,
+		"recovery_codes": hashes,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Turn off two-factor authentication and remove the secret and recovery codes
+func (c *Client) ResetTOTP(email string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	userCol := c.database().Collection("user")
+	result, err := userCol.UpdateOne(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{
+		"totp_secret":    "",
+		"totp_enabled":   false,
+		"recovery_codes": []string{},
+		"totp_step":      0,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNOTFOUND
+	}
+
+	return nil
+}
+
+// Accept a TOTP time step after the last accepted one. A single update, so
+// concurrent requests with the same code can't both use it.
+func (c *Client) UseTOTPStep(email string, step int64) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	userCol := c.database().Collection("user")
+	filter := bson.M{"email": email, "$or": bson.A{
+		bson.M{"totp_step": bson.M{"$lt": step}},
+		bson.M{"totp_step": bson.M{"$exists": false}},
+	}}
+	result, err := userCol.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp_step": step}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount != 1 {
+		return ErrINVALIDPASSWORD
+	}
+
+	return nil
+}
+
+// Mark the token exchanged for a session with a second factor as used, until it expires
+func (c *Client) UseMFAToken(id string, expires time.Time) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	mfaCol := c.shared().Collection("mfatoken")
+	_, err := mfaCol.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "expires", Value: expires},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrINVALIDPASSWORD
+	}
+
+	return err
+}
+
+// Check a recovery code and remove it so it can't be used again
+func (c *Client) UseRecoveryCode(email, code string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	var dbUser model.User
+	userCol := c.database().Collection("user")
+	if err := userCol.FindOne(ctx, bson.M{"email": email}).Decode(&dbUser); err != nil {
+		return ErrNOTFOUND
+	}
+
+	for _, hash := range dbUser.RecoveryCodes {
+		if checkPassword(hash, code) {
+			// only the request removing the code may use it
+			result, err := userCol.UpdateOne(ctx, bson.M{"email": email, "recovery_codes": hash}, bson.M{"$pull": bson.M{"recovery_codes": hash}})
+			if err != nil {
+				return err
+			}
+			if result.ModifiedCount != 1 {
+				return ErrINVALIDPASSWORD
+			}
+			return nil
+		}
+	}
+
+	return ErrINVALIDPASSWORD
+}
+
 // Check the password is correct or not.
 // This method will return an error if the hash does not match the provided password string.
 func checkPassword(existingHash, incomingPass string) bool {
@@ -3,10 +3,19 @@ module app
 go 1.14
 
 require (
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/go-ldap/ldap/v3 v3.4.1
+	github.com/golang-jwt/jwt v3.2.2+incompatible
+	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/gopcua/opcua v0.1.13
 	github.com/gorilla/websocket v1.4.2
 	github.com/labstack/echo/v4 v4.6.1
 	github.com/pion/webrtc/v3 v3.1.3
-	go.mongodb.org/mongo-driver v1.7.3 // indirect
+	github.com/prometheus/client_golang v1.10.0
+	github.com/prometheus/procfs v0.7.3 // indirect
+	go.mongodb.org/mongo-driver v1.7.3
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	golang.org/x/sync v0.0.0-20220907140024-f12130a52804 // indirect
+	google.golang.org/protobuf v1.27.1 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
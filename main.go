@@ -1,7 +1,94 @@
 package main
 
-import "app/rest"
+import (
+	"app/config"
+	"app/db"
+	"app/model"
+	"app/rest"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
 
 func main() {
-	rest.RunAPIWithHandler()
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.PrintConfig {
+		fmt.Print(cfg)
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(cfg.Args) > 0 {
+		switch cfg.Args[0] {
+		case "create-admin":
+			err = createAdmin(cfg, cfg.Args[1:])
+		default:
+			err = fmt.Errorf("unknown command: %s", cfg.Args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	for _, warning := range cfg.Warnings() {
+		log.Print(warning)
+	}
+	if err := rest.RunAPIWithHandler(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// create-admin <email> <firstname> <lastname>: add a super-admin to the
+// default tenant, the way to get the first admin of a new deployment.
+// The password is read from the standard input so it doesn't show in ps.
+func createAdmin(cfg config.Config, args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: create-admin <email> <firstname> <lastname>, password on standard input")
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("reading the password: %v", err)
+	}
+	body := model.SignUp{Email: args[0], FirstName: args[1], LastName: args[2], Password: strings.TrimRight(password, "\r\n")}
+	if err := body.Validate(); err != nil {
+		return err
+	}
+
+	client, err := db.NewClient(cfg.Mongo)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+	if err := client.WaitForServer(cfg.Mongo.StartupTimeoutDuration()); err != nil {
+		return err
+	}
+	if err := client.CreateIndexes(); err != nil {
+		return err
+	}
+	err = client.UserSignUp(model.User{
+		FirstName: body.FirstName,
+		LastName:  body.LastName,
+		Email:     body.Email,
+		Password:  body.Password,
+		Roles:     []string{model.RoleSuperAdmin},
+	})
+	if err == db.ErrINVALIDDATA {
+		return fmt.Errorf("%s already has an account, grant it superadmin instead", body.Email)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("super-admin %s created", body.Email)
+	return nil
 }
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus collectors of the server, served on /metrics
+var (
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of the HTTP requests by route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	PeerConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webrtc_peer_connections",
+		Help: "Open WebRTC peer connections.",
+	})
+
+	CameraFrames = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "camera_frames_total",
+		Help: "H264 frames written to the WebRTC tracks by camera.",
+	}, []string{"tenant", "camera"})
+
+	CameraBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "camera_bytes_total",
+		Help: "H264 bytes written to the WebRTC tracks by camera.",
+	}, []string{"tenant", "camera"})
+
+	FFmpegRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ffmpeg_restarts_total",
+		Help: "ffmpeg restarts after the camera source changed.",
+	}, []string{"tenant", "camera"})
+
+	OpcUADelivered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "opcua_messages_delivered_total",
+		Help: "OPC UA data change messages delivered, by server.",
+	}, []string{"tenant", "server"})
+
+	OpcUADropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "opcua_messages_dropped_total",
+		Help: "OPC UA data change messages dropped, by server.",
+	}, []string{"tenant", "server"})
+
+	MongoCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongo_command_duration_seconds",
+		Help:    "Latency of the MongoDB commands by command and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"command", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		HTTPRequestDuration,
+		PeerConnections,
+		CameraFrames,
+		CameraBytes,
+		FFmpegRestarts,
+		OpcUADelivered,
+		OpcUADropped,
+		MongoCommandDuration,
+	)
+}
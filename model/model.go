@@ -1,18 +1,275 @@
 package model
 
+import "time"
+
+// User roles, from the least to the most privileged.
+// A role grants everything the roles before it grant.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin" // administers their own tenant
+	// administers the tenants, only in the default tenant
+	RoleSuperAdmin = "superadmin"
+)
+
+var roleRank = map[string]int{
+	RoleViewer:     1,
+	RoleOperator:   2,
+	RoleAdmin:      3,
+	RoleSuperAdmin: 4,
+}
+
+// Customer whose data is kept in its own database
+type Tenant struct {
+	ID        string    `json:"id" bson:"_id"`
+	Name      string    `json:"name" bson:"name"`
+	CreatedAt time.Time `json:"created" bson:"created"`
+}
+
 type User struct {
 	LastName  string   `json:"lastname" bson:"lastname"`
 	FirstName string   `json:"firstname" bson:"firstname"`
 	Email     string   `json:"email" bson:"email"`
 	Password  string   `json:"-" bson:"password"`
+	Roles     []string `json:"roles" bson:"roles"`
+	Disabled  bool     `json:"disabled" bson:"disabled"`
+	Source    string   `json:"source,omitempty" bson:"source,omitempty"` // directory that provisioned the user, empty for local users
+	Sites     []string `json:"sites,omitempty" bson:"sites,omitempty"`   // the only sites the user sees, every site when empty
+	// two-factor authentication
+	TOTPSecret    string   `json:"-" bson:"totp_secret"`
+	TOTPEnabled   bool     `json:"totp_enabled" bson:"totp_enabled"`
+	RecoveryCodes []string `json:"-" bson:"recovery_codes"` // bcrypt hashes, like the password
+	TOTPStep      int64    `json:"-" bson:"totp_step"`      // last accepted time step, codes can't be used twice
+}
+
+// Fields of a user an admin can change, nil fields are left as they are
+type UserUpdate struct {
+	LastName  *string  `json:"lastname"`
+	FirstName *string  `json:"firstname"`
+	Password  *string  `json:"password"`
 	Roles     []string `json:"roles"`
+	Sites     []string `json:"sites"` // empty to lift the restriction
+}
+
+// Sign-in session, refreshed with a refresh token until it expires or is revoked
+type Session struct {
+	ID        string    `json:"id" bson:"_id"`
+	Email     string    `json:"email" bson:"email"`
+	Refresh   string    `json:"-" bson:"refresh"` // sha256 of the current refresh token
+	CreatedAt time.Time `json:"created" bson:"created"`
+	ExpiresAt time.Time `json:"expires" bson:"expires"`
+	Revoked   bool      `json:"revoked" bson:"revoked"`
+	MFA       bool      `json:"mfa" bson:"mfa"` // signed in with a second factor
+	Tenant    string    `json:"tenant,omitempty" bson:"tenant,omitempty"`
+}
+
+// Single-use password reset token
+type PasswordReset struct {
+	Token     string    `bson:"_id"` // sha256 of the token sent to the user
+	Email     string    `bson:"email"`
+	ExpiresAt time.Time `bson:"expires"`
+	Used      bool      `bson:"used"`
+	Tenant    string    `bson:"tenant,omitempty"`
+}
+
+// Failed sign-in attempts of an account or a client address
+type LoginAttempt struct {
+	Key         string    `bson:"_id"` // "email:<email>" or "ip:<address>"
+	Failures    int       `bson:"failures"`
+	LastFailure time.Time `bson:"last"`
+	LockedUntil time.Time `bson:"locked"`
+}
+
+// Long-lived key for machine clients, acting for its owner with at most the scope role
+type APIKey struct {
+	ID        string     `json:"id" bson:"_id"`
+	Name      string     `json:"name" bson:"name"`
+	Email     string     `json:"email" bson:"email"` // owner
+	Hash      string     `json:"-" bson:"hash"`      // sha256 of the key
+	Scope     string     `json:"scope" bson:"scope"`
+	CreatedAt time.Time  `json:"created" bson:"created"`
+	ExpiresAt *time.Time `json:"expires,omitempty" bson:"expires,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty" bson:"last_used,omitempty"`
+	Revoked   bool       `json:"revoked" bson:"revoked"`
+	Tenant    string     `json:"tenant,omitempty" bson:"tenant,omitempty"`
 }
 
+// Append-only record of who changed what
+type AuditEntry struct {
+	Time   time.Time              `json:"time" bson:"time"`
+	Actor  string                 `json:"actor" bson:"actor"`
+	Action string                 `json:"action" bson:"action"`
+	Target string                 `json:"target" bson:"target"`
+	Before map[string]interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty" bson:"after,omitempty"`
+	IP     string                 `json:"ip" bson:"ip"`
+}
+
+// Audit query, empty fields match everything
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	From   time.Time
+	To     time.Time
+}
+
+// Kinds of locations, a site holds areas and an area holds gates
+const (
+	LocationSite = "site"
+	LocationArea = "area"
+)
+
+// Site or area of the location hierarchy
+type Location struct {
+	ID     string `json:"id" bson:"id"`
+	Name   string `json:"name" bson:"name"`
+	Kind   string `json:"kind" bson:"kind"`
+	Parent string `json:"parent" bson:"parent"` // site of an area, empty for a site
+}
+
+// Changes to a location, nil fields are left as they are
+type LocationUpdate struct {
+	Name   *string `json:"name"`
+	Parent *string `json:"parent"`
+}
+
+// Apply the changes to a copy of the location
+func (u LocationUpdate) Apply(loc Location) Location {
+	if u.Name != nil {
+		loc.Name = *u.Name
+	}
+	if u.Parent != nil {
+		loc.Parent = *u.Parent
+	}
+	return loc
+}
+
+// A location with the areas or gates directly under it
+type LocationView struct {
+	Location
+	Areas []Location `json:"areas,omitempty"`
+	Gates []Gate     `json:"gates,omitempty"`
+}
+
+// Gates refer to their area and machines by ID, cameras and servers by name
 type Gate struct {
-	Name     string   `json:"name" bson:"name"`
-	Location string   `json:"location" bson:"location"`
-	Machine  []string `json:"machine" bson:"machine"`
-	Cameras  []Camera
+	Name     string       `json:"name" bson:"name"`
+	Location string       `json:"location" bson:"location"`
+	Machine  []string     `json:"machine" bson:"machine"`
+	Cameras  []string     `json:"cameras" bson:"cameras"`
+	Servers  []GateServer `json:"servers" bson:"servers"`
+}
+
+// OPC UA server attached to a gate, with the nodes shown at the gate.
+// No nodes means every node of the server.
+type GateServer struct {
+	Server string   `json:"server" bson:"server"`
+	NodeID []string `json:"nodeid" bson:"nodeid"`
+}
+
+// Changes to a gate, nil fields are left as they are
+type GateUpdate struct {
+	Name     *string      `json:"name"`
+	Location *string      `json:"location"`
+	Machine  []string     `json:"machine"`
+	Cameras  []string     `json:"cameras"`
+	Servers  []GateServer `json:"servers"`
+}
+
+// Apply the changes to a copy of the gate
+func (u GateUpdate) Apply(gate Gate) Gate {
+	if u.Name != nil {
+		gate.Name = *u.Name
+	}
+	if u.Location != nil {
+		gate.Location = *u.Location
+	}
+	if u.Machine != nil {
+		gate.Machine = u.Machine
+	}
+	if u.Cameras != nil {
+		gate.Cameras = u.Cameras
+	}
+	if u.Servers != nil {
+		gate.Servers = u.Servers
+	}
+	return gate
+}
+
+// Request body to attach a camera to a gate
+type GateCamera struct {
+	Name string `json:"name"`
+}
+
+// A gate with the cameras and servers it refers to, everything its monitoring page shows
+type GateView struct {
+	Name     string        `json:"name"`
+	Location string        `json:"location"`
+	Machine  []Machine     `json:"machine"`
+	Cameras  []Camera      `json:"cameras"`
+	Servers  []OpcUAServer `json:"servers"` // with the nodes shown at the gate
+}
+
+// Machine described by the nodes of an OPC UA server and watched by cameras
+type Machine struct {
+	ID          string       `json:"id" bson:"id"`
+	Description string       `json:"description" bson:"description"`
+	Asset       MachineAsset `json:"asset" bson:"asset"`
+	Server      string       `json:"server" bson:"server"`
+	NodeID      []string     `json:"nodeid" bson:"nodeid"`
+	Cameras     []string     `json:"cameras" bson:"cameras"`
+}
+
+// Asset metadata of a machine
+type MachineAsset struct {
+	Manufacturer string            `json:"manufacturer" bson:"manufacturer"`
+	Model        string            `json:"model" bson:"model"`
+	SerialNumber string            `json:"serial" bson:"serial"`
+	InstalledAt  *time.Time        `json:"installed,omitempty" bson:"installed,omitempty"`
+	Extra        map[string]string `json:"extra,omitempty" bson:"extra,omitempty"`
+}
+
+// Changes to a machine, nil fields are left as they are
+type MachineUpdate struct {
+	ID          *string       `json:"id"`
+	Description *string       `json:"description"`
+	Asset       *MachineAsset `json:"asset"`
+	Server      *string       `json:"server"`
+	NodeID      []string      `json:"nodeid"`
+	Cameras     []string      `json:"cameras"`
+}
+
+// Apply the changes to a copy of the machine
+func (u MachineUpdate) Apply(machine Machine) Machine {
+	if u.ID != nil {
+		machine.ID = *u.ID
+	}
+	if u.Description != nil {
+		machine.Description = *u.Description
+	}
+	if u.Asset != nil {
+		machine.Asset = *u.Asset
+	}
+	if u.Server != nil {
+		machine.Server = *u.Server
+	}
+	if u.NodeID != nil {
+		machine.NodeID = u.NodeID
+	}
+	if u.Cameras != nil {
+		machine.Cameras = u.Cameras
+	}
+	return machine
+}
+
+// A machine with its cameras and server, everything its page shows
+type MachineView struct {
+	ID          string       `json:"id"`
+	Description string       `json:"description"`
+	Asset       MachineAsset `json:"asset"`
+	Server      *OpcUAServer `json:"server"` // with the nodes of the machine, nil without server
+	Cameras     []Camera     `json:"cameras"`
 }
 
 type Camera struct {
@@ -21,6 +278,27 @@ type Camera struct {
 	Rtsp  string `json:"rtsp" bson:"rtsp"`
 }
 
+// Fields of a camera to change, nil fields are left as they are
+type CameraUpdate struct {
+	Name  *string `json:"name"`
+	Codec *string `json:"codec"`
+	Rtsp  *string `json:"rtsp"`
+}
+
+// Apply the changes to a copy of the camera
+func (u CameraUpdate) Apply(cam Camera) Camera {
+	if u.Name != nil {
+		cam.Name = *u.Name
+	}
+	if u.Codec != nil {
+		cam.Codec = *u.Codec
+	}
+	if u.Rtsp != nil {
+		cam.Rtsp = *u.Rtsp
+	}
+	return cam
+}
+
 type OpcUAServer struct {
 	Name     string   `json:"name" bson:"name"`
 	Endpoint string   `json:"endpoint" bson:"endpoint"`
@@ -30,3 +308,61 @@ type OpcUAServer struct {
 	Key      string   `json:"key" bson:"key"`
 	NodeID   []string `json:"nodeid" bson:"nodeid"`
 }
+
+// Changes to an OPC UA server, nil fields are left as they are
+type OpcUAServerUpdate struct {
+	Name     *string  `json:"name"`
+	Endpoint *string  `json:"endpoint"`
+	Policy   *string  `json:"policy"`
+	Mode     *string  `json:"mode"`
+	Cert     *string  `json:"cert"`
+	Key      *string  `json:"key"`
+	NodeID   []string `json:"nodeid"`
+}
+
+// Apply the changes to a copy of the server
+func (u OpcUAServerUpdate) Apply(server OpcUAServer) OpcUAServer {
+	if u.Name != nil {
+		server.Name = *u.Name
+	}
+	if u.Endpoint != nil {
+		server.Endpoint = *u.Endpoint
+	}
+	if u.Policy != nil {
+		server.Policy = *u.Policy
+	}
+	if u.Mode != nil {
+		server.Mode = *u.Mode
+	}
+	if u.Cert != nil {
+		server.Cert = *u.Cert
+	}
+	if u.Key != nil {
+		server.Key = *u.Key
+	}
+	if u.NodeID != nil {
+		server.NodeID = u.NodeID
+	}
+	return server
+}
+
+// Request body to add a node to an OPC UA server
+type OpcUANode struct {
+	NodeID string `json:"nodeid"`
+}
+
+// Check the role is one of the defined roles
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// Check the user has the given role or a more privileged one
+func HasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if roleRank[r] >= roleRank[role] && ValidRole(r) {
+			return true
+		}
+	}
+	return false
+}
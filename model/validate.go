@@ -0,0 +1,305 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+const (
+	minPasswordLength = 8
+	maxNameLength     = 64
+	maxTextLength     = 1024
+)
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	// names of cameras and servers are used in URLs
+	idPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+// Request body to create an account
+type SignUp struct {
+	Tenant    string `json:"tenant"` // must be empty, only the default tenant is open to sign-up
+	LastName  string `json:"lastname"`
+	FirstName string `json:"firstname"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Every invalid field of a request body
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, ", ")
+}
+
+func (v *ValidationErrors) add(field, message string) {
+	*v = append(*v, FieldError{Field: field, Message: message})
+}
+
+// nil when there is no error, so it can be returned as an error
+func (v ValidationErrors) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// Check the password is long enough and mixes letters and digits.
+// Returns an empty string when it is valid.
+func PasswordProblem(password string) string {
+	if len(password) < minPasswordLength {
+		return fmt.Sprintf("must be at least %d characters", minPasswordLength)
+	}
+	var letter, digit bool
+	for _, r := range password {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+	if !letter || !digit {
+		return "must contain letters and digits"
+	}
+	return ""
+}
+
+func nameProblem(name string) string {
+	if strings.TrimSpace(name) == "" {
+		return "is required"
+	}
+	if len(name) > maxNameLength {
+		return fmt.Sprintf("must be at most %d characters", maxNameLength)
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !strings.ContainsRune(" -'.", r) {
+			return "must only contain letters, spaces, hyphens, apostrophes and periods"
+		}
+	}
+	return ""
+}
+
+func (v *ValidationErrors) checkPassword(field, password string) {
+	if msg := PasswordProblem(password); msg != "" {
+		v.add(field, msg)
+	}
+}
+
+func (v *ValidationErrors) checkName(field, name string) {
+	if msg := nameProblem(name); msg != "" {
+		v.add(field, msg)
+	}
+}
+
+func (v *ValidationErrors) checkID(field, id string) {
+	if !idPattern.MatchString(id) {
+		v.add(field, "must be 1 to 64 letters, digits, '_', '.' or '-'")
+	}
+}
+
+func (v *ValidationErrors) checkURL(field, raw string, schemes ...string) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		v.add(field, "must be a valid URL")
+		return
+	}
+	for _, s := range schemes {
+		if u.Scheme == s {
+			return
+		}
+	}
+	v.add(field, "must use "+strings.Join(schemes, " or "))
+}
+
+func (s SignUp) Validate() error {
+	var v ValidationErrors
+	v.checkName("firstname", s.FirstName)
+	v.checkName("lastname", s.LastName)
+	if !emailPattern.MatchString(s.Email) {
+		v.add("email", "must be a valid e-mail address")
+	}
+	v.checkPassword("password", s.Password)
+	return v.err()
+}
+
+func (u UserUpdate) Validate() error {
+	var v ValidationErrors
+	if u.FirstName != nil {
+		v.checkName("firstname", *u.FirstName)
+	}
+	if u.LastName != nil {
+		v.checkName("lastname", *u.LastName)
+	}
+	if u.Password != nil {
+		v.checkPassword("password", *u.Password)
+	}
+	for _, site := range u.Sites {
+		v.checkID("sites", site)
+	}
+	if u.Roles != nil {
+		if len(u.Roles) == 0 {
+			v.add("roles", "is required")
+		}
+		for _, role := range u.Roles {
+			if !ValidRole(role) {
+				v.add("roles", "unknown role: "+role)
+			}
+		}
+	}
+	return v.err()
+}
+
+func (c Camera) Validate() error {
+	var v ValidationErrors
+	v.checkID("name", c.Name)
+	v.checkURL("rtsp", c.Rtsp, "rtsp", "rtsps")
+	if strings.TrimSpace(c.Codec) == "" {
+		v.add("codec", "is required")
+	}
+	return v.err()
+}
+
+func (o OpcUAServer) Validate() error {
+	var v ValidationErrors
+	v.checkID("name", o.Name)
+	v.checkURL("endpoint", o.Endpoint, "opc.tcp")
+	if o.Policy != "" && !validPolicy(o.Policy) {
+		v.add("policy", "unknown security policy")
+	}
+	if o.Mode != "" && ua.MessageSecurityModeFromString(o.Mode) == ua.MessageSecurityModeInvalid {
+		v.add("mode", "must be None, Sign or SignAndEncrypt")
+	}
+	if len(o.NodeID) == 0 {
+		v.add("nodeid", "is required")
+	}
+	for _, id := range o.NodeID {
+		if !ValidNodeID(id) {
+			v.add("nodeid", "invalid node id: "+id)
+		}
+	}
+	return v.err()
+}
+
+func (g Gate) Validate() error {
+	var v ValidationErrors
+	v.checkID("name", g.Name)
+	if g.Location != "" {
+		v.checkID("location", g.Location)
+	}
+	for _, m := range g.Machine {
+		v.checkID("machine", m)
+	}
+	for _, cam := range g.Cameras {
+		v.checkID("cameras", cam)
+	}
+	seen := map[string]bool{}
+	for _, s := range g.Servers {
+		if seen[s.Server] {
+			v.add("servers", "attached twice: "+s.Server)
+		}
+		seen[s.Server] = true
+		if err := s.Validate(); err != nil {
+			for _, e := range err.(ValidationErrors) {
+				v.add("servers."+e.Field, e.Message)
+			}
+		}
+	}
+	return v.err()
+}
+
+func (l Location) Validate() error {
+	var v ValidationErrors
+	v.checkID("id", l.ID)
+	if strings.TrimSpace(l.Name) == "" || len(l.Name) > maxNameLength {
+		v.add("name", fmt.Sprintf("must be 1 to %d characters", maxNameLength))
+	}
+	switch l.Kind {
+	case LocationSite:
+		if l.Parent != "" {
+			v.add("parent", "a site has no parent")
+		}
+	case LocationArea:
+		v.checkID("parent", l.Parent)
+	default:
+		v.add("kind", "must be site or area")
+	}
+	return v.err()
+}
+
+func (m Machine) Validate() error {
+	var v ValidationErrors
+	v.checkID("id", m.ID)
+	if len(m.Description) > maxTextLength {
+		v.add("description", fmt.Sprintf("must be at most %d characters", maxTextLength))
+	}
+	if m.Server != "" {
+		v.checkID("server", m.Server)
+	} else if len(m.NodeID) > 0 {
+		v.add("server", "is required with nodeid")
+	}
+	for _, id := range m.NodeID {
+		if !ValidNodeID(id) {
+			v.add("nodeid", "invalid node id: "+id)
+		}
+	}
+	for _, cam := range m.Cameras {
+		v.checkID("cameras", cam)
+	}
+	return v.err()
+}
+
+func (g GateCamera) Validate() error {
+	var v ValidationErrors
+	v.checkID("name", g.Name)
+	return v.err()
+}
+
+func (g GateServer) Validate() error {
+	var v ValidationErrors
+	v.checkID("server", g.Server)
+	for _, id := range g.NodeID {
+		if !ValidNodeID(id) {
+			v.add("nodeid", "invalid node id: "+id)
+		}
+	}
+	return v.err()
+}
+
+func (n OpcUANode) Validate() error {
+	var v ValidationErrors
+	if !ValidNodeID(n.NodeID) {
+		v.add("nodeid", "invalid node id: "+n.NodeID)
+	}
+	return v.err()
+}
+
+// Check the node id can be parsed, like "ns=2;s=Tag"
+func ValidNodeID(id string) bool {
+	if id == "" {
+		return false
+	}
+	_, err := ua.ParseNodeID(id)
+	return err == nil
+}
+
+func validPolicy(policy string) bool {
+	uri := ua.FormatSecurityPolicyURI(policy)
+	for _, p := range ua.SecurityPolicyURIs {
+		if p == uri {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package rest
+
+import (
+	"app/db"
+	"app/model"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	apiKeyPrefix = "mk_" // tells API keys apart from access tokens
+)
+
+type apiKeyRequest struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	ExpiresAt *time.Time `json:"expires"`
+}
+
+func (r apiKeyRequest) Validate() error {
+	var v model.ValidationErrors
+	if strings.TrimSpace(r.Name) == "" || len(r.Name) > 64 {
+		v = append(v, model.FieldError{Field: "name", Message: "must be 1 to 64 characters"})
+	}
+	if r.Scope != "" && !model.ValidRole(r.Scope) {
+		v = append(v, model.FieldError{Field: "scope", Message: "unknown role: " + r.Scope})
+	}
+	if r.ExpiresAt != nil && r.ExpiresAt.Before(time.Now()) {
+		v = append(v, model.FieldError{Field: "expires", Message: "must be in the future"})
+	}
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+type apiKeyResponse struct {
+	Key    string       `json:"key"` // only returned once
+	APIKey model.APIKey `json:"apikey"`
+}
+
+// Streams opened with an API key are grouped under the key instead of a session
+func apiKeySession(id string) string {
+	return "apikey:" + id
+}
+
+// Authenticate an API key as its owner, limited to the key scope
+func (h *Handler) apiKeyClaims(raw string) (*Claims, error) {
+	key, err := h.db.UseAPIKey(hashToken(raw))
+	if err != nil {
+		return nil, err
+	}
+	user, err := h.db.ForTenant(key.Tenant).GetUserByEmail(key.Email)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, db.ErrDISABLED
+	}
+
+	// the owner may have been demoted since the key was created
+	roles := []string{key.Scope}
+	if !model.HasRole(user.Roles, key.Scope) {
+		roles = user.Roles
+	}
+
+	claims := &Claims{Email: user.Email, Roles: roles, APIKey: key.ID, Tenant: key.Tenant}
+	claims.Id = apiKeySession(key.ID)
+	return claims, nil
+}
+
+// Close the streams opened with any API key of the user
+func (h *Handler) closeAPIKeyStreams(email, reason string) error {
+	keys, err := h.db.GetAPIKeys(email)
+	for _, key := range keys {
+		h.conns.CloseSession(apiKeySession(key.ID), reason)
+	}
+	return err
+}
+
+// Create an API key for the signed in user
+func (h *Handler) CreateAPIKey(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	claims := currentClaims(c)
+	if claims.APIKey != "" {
+		return c.JSON(http.StatusForbidden, "API keys can't create API keys")
+	}
+
+	var body apiKeyRequest
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(body); err != nil {
+		return invalid(c, err)
+	}
+	if body.Scope == "" {
+		body.Scope = model.RoleViewer
+	}
+	if !model.HasRole(claims.Roles, body.Scope) {
+		return invalid(c, model.ValidationErrors{{Field: "scope", Message: "can't exceed your own role"}})
+	}
+
+	id, err := randomToken(12)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	raw := apiKeyPrefix + secret
+
+	key := model.APIKey{
+		ID:        id,
+		Name:      body.Name,
+		Email:     claims.Email,
+		Hash:      hashToken(raw),
+		Scope:     body.Scope,
+		CreatedAt: time.Now(),
+		ExpiresAt: body.ExpiresAt,
+	}
+	if err := h.db.CreateAPIKey(key); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditAPIKeyCreate, key.ID, nil, key)
+
+	return c.JSON(http.StatusOK, apiKeyResponse{Key: raw, APIKey: key})
+}
+
+// List the API keys of the signed in user
+func (h *Handler) GetAPIKeys(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	keys, err := h.db.GetAPIKeys(currentClaims(c).Email)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// List the API keys of every user, or of the user given by the email query parameter (admin only)
+func (h *Handler) GetAllAPIKeys(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	keys, err := h.db.GetAPIKeys(c.QueryParam("email"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// Revoke an API key of the signed in user. Admins can revoke any key.
+func (h *Handler) RevokeAPIKey(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+	claims := currentClaims(c)
+	if claims.APIKey != "" {
+		return c.JSON(http.StatusForbidden, "API keys can't revoke API keys")
+	}
+
+	owner := claims.Email
+	if model.HasRole(claims.Roles, model.RoleAdmin) && (claims.MFA || !requireAdminMFA) {
+		owner = ""
+	}
+
+	if err := h.db.RevokeAPIKey(param, owner); err != nil {
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.conns.CloseSession(apiKeySession(param), "API key revoked")
+
+	h.audit(c, auditAPIKeyRevoke, param, nil, nil)
+
+	return c.JSON(http.StatusOK, "Success")
+}
@@ -0,0 +1,43 @@
+package rest
+
+import (
+	"app/model"
+	"net/http"
+	"testing"
+)
+
+func (f *fakeDB) RevokeAPIKey(id, email string) error {
+	f.keys = append(f.keys, id)
+	return nil
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		apiKey string
+		code   int
+	}{
+		{"signed in", "", http.StatusOK},
+		{"with an API key", "k2", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeDB("")
+			h := testHandler(store)
+			claims := &Claims{Email: "erin@example.com", Roles: []string{model.RoleOperator}, APIKey: tt.apiKey}
+
+			c, rec := testContext(http.MethodDelete, "", claims, "")
+			c.SetParamNames("id")
+			c.SetParamValues("k1")
+			if err := h.RevokeAPIKey(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if revoked := len(store.keys) > 0; revoked != (tt.code == http.StatusOK) {
+				t.Errorf("revoked %v", store.keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package rest
+
+import (
+	"app/model"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Audited actions
+const (
+	auditSignIn           = "user.signin"
+	auditSignInFailed     = "user.signin.failed"
+	auditSignUp           = "user.signup"
+	auditUserCreate       = "user.create"
+	auditUserUpdate       = "user.update"
+	auditUserDisable      = "user.disable"
+	auditUserEnable       = "user.enable"
+	auditUserDelete       = "user.delete"
+	auditUserRevoke       = "user.sessions.revoke"
+	auditCameraAdd        = "camera.add"
+	auditCameraDelete     = "camera.delete"
+	auditCameraUpdate     = "camera.update"
+	auditServerAdd        = "server.add"
+	auditServerDelete     = "server.delete"
+	auditServerUpdate     = "server.update"
+	auditServerNodeAdd    = "server.node.add"
+	auditServerNodeRemove = "server.node.remove"
+	auditGateAdd          = "gate.add"
+	auditGateDelete       = "gate.delete"
+	auditGateUpdate       = "gate.update"
+	auditMachineAdd       = "machine.add"
+	auditMachineDelete    = "machine.delete"
+	auditMachineUpdate    = "machine.update"
+	auditLocationAdd      = "location.add"
+	auditLocationDelete   = "location.delete"
+	auditLocationUpdate   = "location.update"
+	auditTenantCreate     = "tenant.create"
+	auditStreamCamera     = "stream.camera"
+	auditStreamOpcUA      = "stream.opcua"
+	auditAPIKeyCreate     = "apikey.create"
+	auditAPIKeyRevoke     = "apikey.revoke"
+	auditTOTPReset        = "user.2fa.reset"
+	auditAccountUnlock    = "user.unlock"
+)
+
+// Camera for the audit log, without the credentials of its RTSP URL
+func redactedCamera(cam model.Camera) model.Camera {
+	cam.Rtsp = redactedURL(cam.Rtsp)
+	return cam
+}
+
+// Server for the audit log. The certificate and private key are replaced by
+// whether they differ from before, the zero server for a new one.
+func redactedServer(before, server model.OpcUAServer) interface{} {
+	certChanged, keyChanged := server.Cert != before.Cert, server.Key != before.Key
+	server.Endpoint = redactedURL(server.Endpoint)
+	return struct {
+		model.OpcUAServer
+		Cert        string `json:"cert,omitempty"` // hide the embedded ones
+		Key         string `json:"key,omitempty"`
+		CertChanged bool   `json:"cert_changed,omitempty"`
+		KeyChanged  bool   `json:"key_changed,omitempty"`
+	}{OpcUAServer: server, CertChanged: certChanged, KeyChanged: keyChanged}
+}
+
+// URL without its user and password, empty when it can't be parsed
+func redactedURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	return u.String()
+}
+
+// JSON view of a payload, so secrets hidden from JSON are not stored either
+func auditPayload(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return m
+}
+
+// Record an action of the authenticated user
+func (h *Handler) audit(c echo.Context, action, target string, before, after interface{}) {
+	actor := ""
+	if claims := currentClaims(c); claims != nil {
+		actor = claims.Email
+	}
+	h.auditAs(c, actor, action, target, before, after)
+}
+
+// Record an action of the given actor, for requests that are not authenticated yet.
+// A failing audit log doesn't fail the request.
+func (h *Handler) auditAs(c echo.Context, actor, action, target string, before, after interface{}) {
+	entry := model.AuditEntry{
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		Action: action,
+		Target: target,
+		Before: auditPayload(before),
+		After:  auditPayload(after),
+		IP:     c.RealIP(),
+	}
+	if err := h.db.AddAudit(entry); err != nil {
+		log.Printf("audit: %s %s %s: %v", actor, action, target, err)
+	}
+}
+
+// Query the audit log (admin only).
+// Filters: actor, action, target, from and to (RFC 3339), page and size.
+func (h *Handler) GetAudit(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	page, size, err := pagination(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	filter := model.AuditFilter{
+		Actor:  c.QueryParam("actor"),
+		Action: c.QueryParam("action"),
+		Target: c.QueryParam("target"),
+	}
+	var v model.ValidationErrors
+	for name, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if p := c.QueryParam(name); p != "" {
+			parsed, err := time.Parse(time.RFC3339, p)
+			if err != nil {
+				v = append(v, model.FieldError{Field: name, Message: "must be an RFC 3339 time"})
+				continue
+			}
+			*t = parsed
+		}
+	}
+	if len(v) > 0 {
+		return invalid(c, v)
+	}
+
+	entries, total, err := h.db.GetAudit(filter, page, size)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, pageResponse{Items: entries, Total: total, Page: page, Size: size})
+}
@@ -0,0 +1,259 @@
+package rest
+
+import (
+	"app/model"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrUNAUTHORIZED = errors.New("Unauthorized")
+	ErrFORBIDDEN    = errors.New("Forbidden")
+	ErrMFAREQUIRED  = errors.New("Two-factor authentication required")
+
+	// Secret used to sign access tokens, from auth.jwt_secret to keep tokens valid across restarts
+	jwtSecret       = randomSecret()
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 24 * time.Hour
+	mfaTokenTTL     = 5 * time.Minute
+
+	// Admins must have signed in with a second factor to use admin endpoints
+	requireAdminMFA = true
+)
+
+const (
+	claimsKey       = "claims"       // echo context key of the authenticated claims
+	tokenQueryParam = "token"        // websocket clients can't set the Authorization header
+	tokenProtocol   = "access_token" // Sec-WebSocket-Protocol: access_token, <token>
+
+	// token audiences, so a token can't be used for something else
+	accessAudience = "access"
+	mfaAudience    = "mfa"
+)
+
+type Claims struct {
+	Email string   `json:"email"`
+	Roles []string `json:"roles"`
+	MFA   bool     `json:"mfa,omitempty"`
+	// tenant of the user, empty for the default tenant
+	Tenant string `json:"tenant,omitempty"`
+	// set when authenticated with an API key instead of a session
+	APIKey string `json:"-"`
+	jwt.StandardClaims
+}
+
+type signInResponse struct {
+	Token        string     `json:"token"`
+	Expires      int64      `json:"expires"`
+	RefreshToken string     `json:"refresh_token"`
+	User         model.User `json:"user"`
+}
+
+func randomSecret() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Random url-safe token of n bytes
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Tokens are only stored hashed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue a signed access token for the user, bound to a session
+func issueToken(user model.User, tenant, session string, mfa bool) (string, time.Time, error) {
+	claims := &Claims{
+		Email:  user.Email,
+		Roles:  user.Roles,
+		MFA:    mfa,
+		Tenant: tenant,
+	}
+	claims.Id = session
+	return signToken(claims, accessAudience, accessTokenTTL)
+}
+
+// Issue a token proving the password was checked, to be exchanged with a second factor
+func issueMFAToken(user model.User, tenant string) (string, time.Time, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	claims := &Claims{Email: user.Email, Tenant: tenant}
+	claims.Id = id // single-use, see signInSecondFactor
+	return signToken(claims, mfaAudience, mfaTokenTTL)
+}
+
+func signToken(claims *Claims, audience string, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(ttl)
+	claims.Audience = audience
+	claims.Subject = claims.Email
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = expires.Unix()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// Verify the signature, expiry and audience of a token
+func parseToken(raw, audience string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrUNAUTHORIZED
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid || !claims.VerifyAudience(audience, true) {
+		return nil, ErrUNAUTHORIZED
+	}
+	return claims, nil
+}
+
+// Find the access token or API key in the X-API-Key header, the Authorization
+// header, the token query parameter or the websocket subprotocol header, in that order.
+func extractToken(c echo.Context) string {
+	req := c.Request()
+	if key := req.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	if auth := req.Header.Get(echo.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token := c.QueryParam(tokenQueryParam); token != "" {
+		return token
+	}
+	protocols := websocketProtocols(req)
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == tokenProtocol {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+func websocketProtocols(req *http.Request) []string {
+	var protocols []string
+	for _, header := range req.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(header, ",") {
+			protocols = append(protocols, strings.TrimSpace(p))
+		}
+	}
+	return protocols
+}
+
+func unauthorized(c echo.Context) error {
+	return c.JSON(http.StatusUnauthorized, ErrUNAUTHORIZED.Error())
+}
+
+// Claims of the authenticated request, nil when not authenticated
+func currentClaims(c echo.Context) *Claims {
+	claims, _ := c.Get(claimsKey).(*Claims)
+	return claims
+}
+
+// Open a new session for the user and issue its first token pair
+func (h *Handler) startSession(user model.User, mfa bool) (signInResponse, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return signInResponse{}, err
+	}
+	refresh, err := randomToken(32)
+	if err != nil {
+		return signInResponse{}, err
+	}
+
+	now := time.Now()
+	session := model.Session{
+		ID:        id,
+		Email:     user.Email,
+		Refresh:   hashToken(refresh),
+		CreatedAt: now,
+		ExpiresAt: now.Add(refreshTokenTTL),
+		MFA:       mfa,
+	}
+	if err := h.db.CreateSession(session); err != nil {
+		return signInResponse{}, err
+	}
+
+	token, expires, err := issueToken(user, h.db.Tenant(), id, mfa)
+	if err != nil {
+		return signInResponse{}, err
+	}
+	return signInResponse{Token: token, Expires: expires.Unix(), RefreshToken: refresh, User: user}, nil
+}
+
+// Authenticate requests with a valid access token of an active session, or an API key
+func (h *Handler) Authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		raw := extractToken(c)
+		if raw == "" {
+			return unauthorized(c)
+		}
+		if strings.HasPrefix(raw, apiKeyPrefix) {
+			claims, err := h.apiKeyClaims(raw)
+			if err != nil {
+				return unauthorized(c)
+			}
+			c.Set(claimsKey, claims)
+			return next(c)
+		}
+		claims, err := parseToken(raw, accessAudience)
+		if err != nil {
+			return unauthorized(c)
+		}
+		session, err := h.db.GetSession(claims.Id)
+		if err != nil || session.Revoked || time.Now().After(session.ExpiresAt) || session.Tenant != claims.Tenant {
+			return unauthorized(c)
+		}
+		c.Set(claimsKey, claims)
+		return next(c)
+	}
+}
+
+// Only let through authenticated users having the role or a more privileged one.
+// Must be used after Authenticate.
+func (h *Handler) RequireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims := currentClaims(c)
+			if claims == nil {
+				return unauthorized(c)
+			}
+			if !model.HasRole(claims.Roles, role) {
+				return c.JSON(http.StatusForbidden, ErrFORBIDDEN.Error())
+			}
+			if role == model.RoleSuperAdmin && claims.Tenant != "" {
+				return c.JSON(http.StatusForbidden, ErrFORBIDDEN.Error())
+			}
+			if model.HasRole([]string{role}, model.RoleAdmin) && requireAdminMFA && !claims.MFA {
+				return c.JSON(http.StatusForbidden, ErrMFAREQUIRED.Error())
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package rest
+
+import (
+	"app/db"
+	"app/model"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Check the area, machines, cameras and servers the gate refers to exist, and that the
+// attached nodes are nodes of their server
+func (h *Handler) checkGateRefs(gate model.Gate) error {
+	var v model.ValidationErrors
+	if gate.Location != "" {
+		if area, err := h.db.GetLocationByID(gate.Location); err != nil || area.Kind != model.LocationArea {
+			v = append(v, model.FieldError{Field: "location", Message: "unknown area: " + gate.Location})
+		}
+	}
+	for _, id := range gate.Machine {
+		if _, err := h.db.GetMachineByID(id); err != nil {
+			v = append(v, model.FieldError{Field: "machine", Message: "unknown machine: " + id})
+		}
+	}
+	for _, cam := range gate.Cameras {
+		if _, err := h.db.GetCamByID(cam); err != nil {
+			v = append(v, model.FieldError{Field: "cameras", Message: "unknown camera: " + cam})
+		}
+	}
+	for _, s := range gate.Servers {
+		if err := h.checkGateServer(s); err != nil {
+			v = append(v, err.(model.ValidationErrors)...)
+		}
+	}
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (h *Handler) checkGateServer(s model.GateServer) error {
+	return h.checkServerNodes("servers", s.Server, s.NodeID)
+}
+
+// Check the server exists and has the nodes
+func (h *Handler) checkServerNodes(field, name string, nodeIDs []string) error {
+	var v model.ValidationErrors
+	server, err := h.db.GetServerByID(name)
+	if err != nil {
+		v = append(v, model.FieldError{Field: field, Message: "unknown server: " + name})
+		return v
+	}
+	nodes := map[string]bool{}
+	for _, id := range server.NodeID {
+		nodes[id] = true
+	}
+	for _, id := range nodeIDs {
+		if !nodes[id] {
+			v = append(v, model.FieldError{Field: "nodeid", Message: "not a node of " + name + ": " + id})
+		}
+	}
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// Keep the nodes of the server that are in nodeIDs, in the server order
+func onlyNodes(server model.OpcUAServer, nodeIDs []string) model.OpcUAServer {
+	shown := map[string]bool{}
+	for _, id := range nodeIDs {
+		shown[id] = true
+	}
+	var nodes []string
+	for _, id := range server.NodeID {
+		if shown[id] {
+			nodes = append(nodes, id)
+		}
+	}
+	server.NodeID = nodes
+	return server
+}
+
+// Resolve the references of the gate. Machines, cameras and servers deleted meanwhile are left out.
+func (h *Handler) gateView(gate model.Gate) model.GateView {
+	view := model.GateView{
+		Name:     gate.Name,
+		Location: gate.Location,
+		Machine:  []model.Machine{},
+		Cameras:  []model.Camera{},
+		Servers:  []model.OpcUAServer{},
+	}
+	for _, id := range gate.Machine {
+		if machine, err := h.db.GetMachineByID(id); err == nil {
+			view.Machine = append(view.Machine, machine)
+		}
+	}
+	for _, name := range gate.Cameras {
+		if cam, err := h.db.GetCamByID(name); err == nil {
+			view.Cameras = append(view.Cameras, cam)
+		}
+	}
+	for _, s := range gate.Servers {
+		server, err := h.db.GetServerByID(s.Server)
+		if err != nil {
+			continue
+		}
+		if len(s.NodeID) > 0 {
+			server = onlyNodes(server, s.NodeID)
+		}
+		view.Servers = append(view.Servers, server)
+	}
+	return view
+}
+
+// Get ALL Gates
+func (h *Handler) GetAllGates(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	s, err := h.requestScope(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	all, err := h.db.GetAllGates()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	gates := []model.Gate{}
+	for _, gate := range all {
+		if s.gate(gate.Name) {
+			gates = append(gates, gate)
+		}
+	}
+
+	return c.JSON(http.StatusOK, gates)
+}
+
+// Get a Gate with its cameras and servers
+func (h *Handler) GetGateView(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+	s, err := h.requestScope(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if !s.gate(param) {
+		return c.JSON(http.StatusForbidden, ErrFORBIDDEN.Error())
+	}
+
+	gate, err := h.db.GetGateByID(param)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, db.ErrNOTFOUND.Error())
+	}
+
+	return c.JSON(http.StatusOK, h.gateView(gate))
+}
+
+// Add Gate
+func (h *Handler) AddNewGate(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	var gate model.Gate
+	if err := c.Bind(&gate); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(gate); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkGateRefs(gate); err != nil {
+		return invalid(c, err)
+	}
+
+	if err := h.db.AddNewGate(gate); err != nil {
+		if err == db.ErrINVALIDDATA {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditGateAdd, gate.Name, nil, gate)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Delete Gate
+func (h *Handler) DeleteCurrentGate(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	before, _ := h.db.GetGateByID(param)
+	if err := h.db.DeleteGate(param); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.audit(c, auditGateDelete, param, before, nil)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Replace Gate
+func (h *Handler) UpdateCurrentGate(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var gate model.Gate
+	if err := c.Bind(&gate); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	return h.updateGate(c, param, gate)
+}
+
+// Change some fields of Gate
+func (h *Handler) PatchCurrentGate(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var update model.GateUpdate
+	if err := c.Bind(&update); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	before, err := h.db.GetGateByID(param)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, db.ErrNOTFOUND.Error())
+	}
+
+	return h.updateGate(c, param, update.Apply(before))
+}
+
+func (h *Handler) updateGate(c echo.Context, param string, gate model.Gate) error {
+	if err := c.Validate(gate); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkGateRefs(gate); err != nil {
+		return invalid(c, err)
+	}
+
+	before, _ := h.db.GetGateByID(param)
+	if err := h.db.UpdateGate(param, gate); err != nil {
+		if err == db.ErrINVALIDDATA {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditGateUpdate, param, before, gate)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Attach a camera to Gate
+func (h *Handler) AttachGateCam(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var cam model.GateCamera
+	if err := c.Bind(&cam); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(cam); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkGateRefs(model.Gate{Cameras: []string{cam.Name}}); err != nil {
+		return invalid(c, err)
+	}
+
+	return h.changeGate(c, param, func() error {
+		return h.db.AddGateCam(param, cam.Name)
+	})
+}
+
+// Detach a camera from Gate
+func (h *Handler) DetachGateCam(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+	cam := c.Param("cam")
+
+	return h.changeGate(c, param, func() error {
+		return h.db.RemoveGateCam(param, cam)
+	})
+}
+
+// Attach an OPC UA server to Gate, or change its nodes shown at the gate
+func (h *Handler) AttachGateServer(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var server model.GateServer
+	if err := c.Bind(&server); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(server); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkGateServer(server); err != nil {
+		return invalid(c, err)
+	}
+
+	return h.changeGate(c, param, func() error {
+		return h.db.SetGateServer(param, server)
+	})
+}
+
+// Detach an OPC UA server from Gate
+func (h *Handler) DetachGateServer(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+	server := c.Param("server")
+
+	return h.changeGate(c, param, func() error {
+		return h.db.RemoveGateServer(param, server)
+	})
+}
+
+// Run a change of the gate references and audit it
+func (h *Handler) changeGate(c echo.Context, param string, change func() error) error {
+	before, _ := h.db.GetGateByID(param)
+	if err := change(); err != nil {
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	after, _ := h.db.GetGateByID(param)
+
+	h.audit(c, auditGateUpdate, param, before, after)
+
+	return c.JSON(http.StatusOK, "Success")
+}
@@ -1,11 +1,16 @@
 package rest
 
 import (
+	"app/auth"
+	"app/config"
 	"app/db"
 	"app/model"
 	"app/service"
+	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
@@ -15,22 +20,60 @@ var (
 	upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
+		Subprotocols:    []string{tokenProtocol},
 	}
 )
 
 type Handler struct {
-	db db.DBInterface
+	db            db.DBInterface
+	authenticator auth.Authenticator
+	oidc          *auth.OIDCProvider // nil when single sign-on is not configured
+	conns         *service.ConnRegistry
+	cameraStreams *service.CameraStreams
+	serverStreams *service.ServerStreams
+	notifier      service.Notifier
+	ready         *readiness
 }
 
 type HandlerInterface interface {
 	// users
 	SignUp(c echo.Context) error
 	SignIn(c echo.Context) error
+	OIDCLogin(c echo.Context) error
+	OIDCCallback(c echo.Context) error
+	Refresh(c echo.Context) error
+	SignOut(c echo.Context) error
+	UpdateUserRoles(c echo.Context) error
+	RevokeUserSessions(c echo.Context) error
+	// user administration
+	GetUsers(c echo.Context) error
+	CreateUser(c echo.Context) error
+	GetUser(c echo.Context) error
+	UpdateUser(c echo.Context) error
+	DisableUser(c echo.Context) error
+	EnableUser(c echo.Context) error
+	DeleteUser(c echo.Context) error
+	UnlockUser(c echo.Context) error
+	// two-factor authentication
+	EnrollTOTP(c echo.Context) error
+	ConfirmTOTP(c echo.Context) error
+	ResetUserTOTP(c echo.Context) error
+	// api keys
+	CreateAPIKey(c echo.Context) error
+	GetAPIKeys(c echo.Context) error
+	GetAllAPIKeys(c echo.Context) error
+	RevokeAPIKey(c echo.Context) error
+	// passwords
+	ChangePassword(c echo.Context) error
+	ForgotPassword(c echo.Context) error
+	ResetPassword(c echo.Context) error
 	// cameras
 	StreamRTSP(c echo.Context) error
 	AddNewCam(c echo.Context) error
 	GetAllCam(c echo.Context) error
 	DeleteCurrentCam(c echo.Context) error
+	UpdateCurrentCam(c echo.Context) error
+	PatchCurrentCam(c echo.Context) error
 	GetCurrentCam(c echo.Context) error
 	// opcua servers
 	MonitoringOpcUA(c echo.Context) error
@@ -38,14 +81,125 @@ type HandlerInterface interface {
 	GetAllServer(c echo.Context) error
 	GetCurrentServer(c echo.Context) error
 	DeleteCurrentServer(c echo.Context) error
+	UpdateCurrentServer(c echo.Context) error
+	PatchCurrentServer(c echo.Context) error
+	AddServerNode(c echo.Context) error
+	RemoveServerNode(c echo.Context) error
+	// gates
+	GetAllGates(c echo.Context) error
+	GetGateView(c echo.Context) error
+	AddNewGate(c echo.Context) error
+	DeleteCurrentGate(c echo.Context) error
+	UpdateCurrentGate(c echo.Context) error
+	PatchCurrentGate(c echo.Context) error
+	AttachGateCam(c echo.Context) error
+	DetachGateCam(c echo.Context) error
+	AttachGateServer(c echo.Context) error
+	DetachGateServer(c echo.Context) error
+	// machines
+	GetAllMachines(c echo.Context) error
+	GetMachineView(c echo.Context) error
+	StreamMachine(c echo.Context) error
+	AddNewMachine(c echo.Context) error
+	DeleteCurrentMachine(c echo.Context) error
+	UpdateCurrentMachine(c echo.Context) error
+	PatchCurrentMachine(c echo.Context) error
+	// locations
+	GetAllLocations(c echo.Context) error
+	GetLocationView(c echo.Context) error
+	AddNewLocation(c echo.Context) error
+	PatchCurrentLocation(c echo.Context) error
+	DeleteCurrentLocation(c echo.Context) error
+	// tenants
+	CreateTenant(c echo.Context) error
+	GetTenants(c echo.Context) error
+	// audit
+	GetAudit(c echo.Context) error
+	// health
+	Healthz(c echo.Context) error
+	Health(c echo.Context) error
+	Readyz(c echo.Context) error
+	WaitForDatabase(timeout time.Duration) error
+	Shutdown(ctx context.Context) error
+	// middleware
+	Authenticate(next echo.HandlerFunc) echo.HandlerFunc
+	RequireRole(role string) echo.MiddlewareFunc
+	RequireDatabase(next echo.HandlerFunc) echo.HandlerFunc
+	Tenant(handler func(HandlerInterface, echo.Context) error) echo.HandlerFunc
 }
 
-func NewHandler() (HandlerInterface, error) {
-	client, err := db.NewClient()
+func NewHandler(cfg config.Config) (HandlerInterface, error) {
+	client, err := db.NewClient(cfg.Mongo)
 	if err != nil {
 		return nil, err
 	}
-	return &Handler{db: client}, nil
+	// Without a mail server the messages to users go to a file or the log
+	var notifier service.Notifier = service.LogNotifier{}
+	if cfg.Notify.File != "" {
+		notifier = &service.FileNotifier{Path: cfg.Notify.File}
+	}
+
+	authenticator, err := auth.New(cfg.Auth, cfg.LDAP, client)
+	if err != nil {
+		return nil, err
+	}
+
+	oidc, err := auth.NewOIDC(cfg.OIDC, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Handler{
+		db:            client,
+		authenticator: authenticator,
+		oidc:          oidc,
+		conns:         service.NewConnRegistry(),
+		cameraStreams: service.NewCameraStreams(),
+		serverStreams: service.NewServerStreams(),
+		notifier:      notifier,
+		ready:         newReadiness(),
+	}, nil
+}
+
+// Copy of the handler working on the data of the tenant
+func (h *Handler) forTenant(tenant string) *Handler {
+	if h.db == nil || tenant == h.db.Tenant() {
+		return h
+	}
+	t := *h
+	t.db = h.db.ForTenant(tenant)
+	if tenant != "" {
+		// directories and single sign-on provision users of the default tenant only
+		t.authenticator = &auth.Local{DB: t.db}
+		t.oidc = nil
+	}
+	return &t
+}
+
+// Whether the tenant of a request body exists, the default tenant always does
+func (h *Handler) tenantExists(tenant string) bool {
+	if tenant == "" {
+		return true
+	}
+	_, err := h.db.GetTenant(tenant)
+	return err == nil
+}
+
+// Run the handler on the data of the tenant of the authenticated request.
+// Must be used after Authenticate.
+func (h *Handler) Tenant(handler func(HandlerInterface, echo.Context) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := currentClaims(c)
+		if claims == nil {
+			return unauthorized(c)
+		}
+		return handler(h.forTenant(claims.Tenant), c)
+	}
+}
+
+// Key of a camera or server in the stream registries, names are unique within a tenant only
+func (h *Handler) streamKey(name string) string {
+	return h.db.Tenant() + "/" + name
 }
 
 // User Sign in
@@ -54,20 +208,134 @@ func (h *Handler) SignIn(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "server database error")
 	}
 
-	var user model.User
-	if err := c.Bind(&user); err != nil {
+	// model.User never reads the password from JSON
+	var body signInRequest
+	if err := c.Bind(&body); err != nil {
 		return c.JSON(http.StatusBadRequest, err.Error())
 	}
+	if body.MFAToken != "" {
+		return h.signInSecondFactor(c, body)
+	}
+	if !h.tenantExists(body.Tenant) {
+		return c.JSON(http.StatusUnauthorized, ErrSIGNIN.Error())
+	}
+	h = h.forTenant(body.Tenant)
 
-	user, err := h.db.UserSignIn(user.Email, user.Password)
+	until, err := h.lockedUntil(accountKey(body.Email), addressKey(c.RealIP()))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if !until.IsZero() {
+		return locked(c, until)
+	}
+
+	user, err := h.authenticator.Authenticate(body.Email, body.Password)
 	if err != nil {
 		if err == db.ErrINVALIDPASSWORD {
+			h.recordFailure(body.Email, c.RealIP())
+			h.auditAs(c, body.Email, auditSignInFailed, body.Email, nil, nil)
+			return c.JSON(http.StatusUnauthorized, ErrSIGNIN.Error())
+		}
+		if err == db.ErrDISABLED || err == db.ErrSOURCE || err == auth.ErrNOROLE {
 			return c.JSON(http.StatusForbidden, err.Error())
 		}
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
-	fmt.Println(user)
-	return c.JSON(http.StatusOK, user)
+	if err := h.db.ClearLoginAttempts(accountKey(body.Email)); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if user.TOTPEnabled {
+		token, expires, err := issueMFAToken(user, h.db.Tenant())
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, mfaResponse{MFARequired: true, MFAToken: token, Expires: expires.Unix()})
+	}
+
+	resp, err := h.startSession(user, false)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.auditAs(c, user.Email, auditSignIn, user.Email, nil, map[string]string{"method": "password"})
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// Exchange a refresh token for a new access token and refresh token
+func (h *Handler) Refresh(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if body.RefreshToken == "" {
+		return unauthorized(c)
+	}
+
+	refresh, err := randomToken(32)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	session, err := h.db.RotateSession(hashToken(body.RefreshToken), hashToken(refresh))
+	if err != nil {
+		if err == db.ErrNOTFOUND {
+			return unauthorized(c)
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	// reload the user so role changes apply from the next token
+	h = h.forTenant(session.Tenant)
+	user, err := h.db.GetUserByEmail(session.Email)
+	if err != nil || user.Disabled {
+		return unauthorized(c)
+	}
+
+	token, expires, err := issueToken(user, session.Tenant, session.ID, session.MFA)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, signInResponse{Token: token, Expires: expires.Unix(), RefreshToken: refresh, User: user})
+}
+
+// Revoke the current session and close its streams
+func (h *Handler) SignOut(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	claims := currentClaims(c)
+	if claims.APIKey != "" {
+		return c.JSON(http.StatusBadRequest, "API keys have no session, revoke the key instead")
+	}
+
+	if err := h.db.RevokeSession(claims.Id); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.conns.CloseSession(claims.Id, "signed out")
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Revoke every session of a user and close their streams (admin only)
+func (h *Handler) RevokeUserSessions(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("email")
+
+	if err := h.revokeUser(param, "session revoked"); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditUserRevoke, param, nil, nil)
+
+	return c.JSON(http.StatusOK, "Success")
 }
 
 // User Sign up
@@ -76,11 +344,30 @@ func (h *Handler) SignUp(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "server database error")
 	}
 
-	var user model.User
-	if err := c.Bind(&user); err != nil {
+	var body model.SignUp
+	if err := c.Bind(&body); err != nil {
 		return c.JSON(http.StatusBadRequest, err.Error())
 	}
+	if err := c.Validate(body); err != nil {
+		return invalid(c, err)
+	}
+	// joining another tenant takes an admin of the tenant, see CreateUser
+	if body.Tenant != "" {
+		return invalid(c, model.ValidationErrors{{Field: "tenant", Message: "sign-up is only open in the default tenant"}})
+	}
+
+	if !h.authenticator.LocalPasswords() {
+		return c.JSON(http.StatusForbidden, ErrDIRECTORY.Error())
+	}
 
+	// new users are viewers until an admin promotes them, the first admin is
+	// created with the create-admin command
+	user := model.User{
+		FirstName: body.FirstName,
+		LastName:  body.LastName,
+		Email:     body.Email,
+		Password:  body.Password,
+	}
 	err := h.db.UserSignUp(user)
 	if err != nil {
 		if err == db.ErrINVALIDDATA {
@@ -89,6 +376,49 @@ func (h *Handler) SignUp(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
+	h.auditAs(c, user.Email, auditSignUp, user.Email, nil, user)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Change the roles of a user (admin only)
+func (h *Handler) UpdateUserRoles(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("email")
+
+	var body struct {
+		Roles []string `json:"roles"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if len(body.Roles) == 0 {
+		return c.JSON(http.StatusBadRequest, "roles are required")
+	}
+	for _, role := range body.Roles {
+		if !model.ValidRole(role) {
+			return c.JSON(http.StatusBadRequest, fmt.Sprintf("unknown role: %s", role))
+		}
+	}
+	if err := h.checkGrantedRoles(c, body.Roles); err != nil {
+		return invalid(c, err)
+	}
+
+	if err := h.db.UpdateUserRoles(param, body.Roles); err != nil {
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	// tokens carry the roles, the user signs in again to get the new ones
+	if err := h.revokeUser(param, "roles changed"); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditUserUpdate, param, nil, body)
+
 	return c.JSON(http.StatusOK, "Success")
 }
 
@@ -98,6 +428,13 @@ func (h *Handler) StreamRTSP(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "server database error")
 	}
 	param := c.Param("id")
+	s, err := h.requestScope(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if !s.camera(param) {
+		return c.JSON(http.StatusForbidden, ErrFORBIDDEN.Error())
+	}
 	// websocket
 	cam, err := h.db.GetCamByID(param)
 	if err != nil {
@@ -108,8 +445,16 @@ func (h *Handler) StreamRTSP(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, err)
 	}
-	ws := &service.ThreadSafeWriter{Conn: unSafeconn}
-	go ws.WebRTCStreamH264(cam.Rtsp) // controller 보내기
+	ws := &service.ThreadSafeWriter{Conn: unSafeconn, Tenant: h.db.Tenant()}
+	session := currentClaims(c).Id
+	h.conns.Add(session, ws)
+	source := h.cameraStreams.Add(h.streamKey(cam.Name), cam.Rtsp)
+	service.Go(func() {
+		defer h.conns.Remove(session, ws)
+		defer h.cameraStreams.Remove(source)
+		ws.WebRTCStreamH264(cam.Name, source) // controller 보내기
+	})
+	h.audit(c, auditStreamCamera, cam.Name, nil, nil)
 
 	return c.JSON(http.StatusOK, "Ready to stream")
 }
@@ -124,11 +469,16 @@ func (h *Handler) AddNewCam(c echo.Context) error {
 	if err := c.Bind(&cam); err != nil {
 		return c.JSON(http.StatusBadRequest, err.Error())
 	}
+	if err := c.Validate(cam); err != nil {
+		return invalid(c, err)
+	}
 
 	if err := h.db.AddNewCam(cam); err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
+	h.audit(c, auditCameraAdd, cam.Name, nil, redactedCamera(cam))
+
 	return c.JSON(http.StatusOK, "Success")
 }
 
@@ -139,9 +489,73 @@ func (h *Handler) DeleteCurrentCam(c echo.Context) error {
 	}
 	param := c.Param("id")
 
+	before, _ := h.db.GetCamByID(param)
 	if err := h.db.DeleteCam(param); err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
+	h.audit(c, auditCameraDelete, param, redactedCamera(before), nil)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Replace RTSP Camera
+func (h *Handler) UpdateCurrentCam(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var cam model.Camera
+	if err := c.Bind(&cam); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	return h.updateCam(c, param, cam)
+}
+
+// Change some fields of RTSP Camera
+func (h *Handler) PatchCurrentCam(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var update model.CameraUpdate
+	if err := c.Bind(&update); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	before, err := h.db.GetCamByID(param)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, db.ErrNOTFOUND.Error())
+	}
+
+	return h.updateCam(c, param, update.Apply(before))
+}
+
+// Save the camera and restart its live streams with the new settings
+func (h *Handler) updateCam(c echo.Context, param string, cam model.Camera) error {
+	if err := c.Validate(cam); err != nil {
+		return invalid(c, err)
+	}
+
+	before, _ := h.db.GetCamByID(param)
+	if err := h.db.UpdateCam(param, cam); err != nil {
+		if err == db.ErrINVALIDDATA {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	restart := cam.Rtsp
+	if restart == before.Rtsp {
+		restart = ""
+	}
+	h.cameraStreams.Update(h.streamKey(param), h.streamKey(cam.Name), restart)
+
+	h.audit(c, auditCameraUpdate, param, redactedCamera(before), redactedCamera(cam))
 
 	return c.JSON(http.StatusOK, "Success")
 }
@@ -152,6 +566,13 @@ func (h *Handler) GetCurrentCam(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "server database error")
 	}
 	param := c.Param("id")
+	s, err := h.requestScope(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if !s.camera(param) {
+		return c.JSON(http.StatusForbidden, ErrFORBIDDEN.Error())
+	}
 
 	cam, err := h.db.GetCamByID(param)
 	if err != nil {
@@ -167,33 +588,60 @@ func (h *Handler) GetAllCam(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "server database error")
 	}
 
-	cams, err := h.db.GetAllCam()
+	s, err := h.requestScope(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	all, err := h.db.GetAllCam()
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
+	cams := []model.Camera{}
+	for _, cam := range all {
+		if s.camera(cam.Name) {
+			cams = append(cams, cam)
+		}
+	}
 
 	return c.JSON(http.StatusOK, cams)
 }
 
-// Monitoring OPC UA Server
+// Streaming the node values of an OPC UA Server
 func (h *Handler) MonitoringOpcUA(c echo.Context) error {
 	if h.db == nil {
 		return c.JSON(http.StatusInternalServerError, "server database error")
 	}
-	opcs, err := h.db.GetAllServer()
-	if err != nil || len(opcs) == 0 {
-		return c.JSON(http.StatusBadRequest, err.Error())
+	param := c.Param("id")
+	s, err := h.requestScope(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if !s.server(param) {
+		return c.JSON(http.StatusForbidden, ErrFORBIDDEN.Error())
+	}
+	opc, err := h.db.GetServerByID(param)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, db.ErrNOTFOUND.Error())
 	}
 
 	unSafeconn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, err)
 	}
-	ws := &service.ThreadSafeWriter{Conn: unSafeconn}
-
-	go ws.SetConfigurationAndRun(opcs[0])
-
-	return c.JSON(http.StatusOK, opcs)
+	ws := &service.ThreadSafeWriter{Conn: unSafeconn, Tenant: h.db.Tenant()}
+	session := currentClaims(c).Id
+	h.conns.Add(session, ws)
+	// only the nodes of the gates and machines in scope
+	nodes := s.serverNodes(opc.Name)
+	source := h.serverStreams.AddFiltered(h.streamKey(opc.Name), opc, nodes)
+	service.Go(func() {
+		defer h.conns.Remove(session, ws)
+		defer h.serverStreams.Remove(source)
+		ws.SetConfigurationAndRun(source)
+	})
+	h.audit(c, auditStreamOpcUA, opc.Name, nil, nil)
+
+	return c.JSON(http.StatusOK, service.FilterNodes(opc, nodes))
 }
 
 // Add OPC UA Server
@@ -206,11 +654,16 @@ func (h *Handler) AddNewServer(c echo.Context) error {
 	if err := c.Bind(&opc); err != nil {
 		return c.JSON(http.StatusBadRequest, err.Error())
 	}
+	if err := c.Validate(opc); err != nil {
+		return invalid(c, err)
+	}
 
 	if err := h.db.AddNewServer(opc); err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
+	h.audit(c, auditServerAdd, opc.Name, nil, redactedServer(model.OpcUAServer{}, opc))
+
 	return c.JSON(http.StatusOK, "Success")
 }
 
@@ -221,9 +674,11 @@ func (h *Handler) DeleteCurrentServer(c echo.Context) error {
 	}
 	param := c.Param("id")
 
+	before, _ := h.db.GetServerByID(param)
 	if err := h.db.DeleteServer(param); err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
+	h.audit(c, auditServerDelete, param, redactedServer(before, before), nil)
 
 	return c.JSON(http.StatusOK, "Success")
 }
@@ -234,6 +689,13 @@ func (h *Handler) GetCurrentServer(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "server database error")
 	}
 	param := c.Param("id")
+	s, err := h.requestScope(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if !s.server(param) {
+		return c.JSON(http.StatusForbidden, ErrFORBIDDEN.Error())
+	}
 
 	opc, err := h.db.GetServerByID(param)
 	if err != nil {
@@ -249,10 +711,139 @@ func (h *Handler) GetAllServer(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "server database error")
 	}
 
-	opcs, err := h.db.GetAllServer()
+	s, err := h.requestScope(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	all, err := h.db.GetAllServer()
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
+	opcs := []model.OpcUAServer{}
+	for _, opc := range all {
+		if s.server(opc.Name) {
+			opcs = append(opcs, opc)
+		}
+	}
 
 	return c.JSON(http.StatusOK, opcs)
 }
+
+// Replace OPC UA Server
+func (h *Handler) UpdateCurrentServer(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var opc model.OpcUAServer
+	if err := c.Bind(&opc); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	return h.updateServer(c, param, opc)
+}
+
+// Change some fields of OPC UA Server
+func (h *Handler) PatchCurrentServer(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var update model.OpcUAServerUpdate
+	if err := c.Bind(&update); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	before, err := h.db.GetServerByID(param)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, db.ErrNOTFOUND.Error())
+	}
+
+	return h.updateServer(c, param, update.Apply(before))
+}
+
+// Save the server and send it to its live streams
+func (h *Handler) updateServer(c echo.Context, param string, opc model.OpcUAServer) error {
+	if err := c.Validate(opc); err != nil {
+		return invalid(c, err)
+	}
+
+	before, _ := h.db.GetServerByID(param)
+	if err := h.db.UpdateServer(param, opc); err != nil {
+		if err == db.ErrINVALIDDATA {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.serverStreams.Update(h.streamKey(param), h.streamKey(opc.Name), opc)
+
+	h.audit(c, auditServerUpdate, param, redactedServer(before, before), redactedServer(before, opc))
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Add a node to OPC UA Server
+func (h *Handler) AddServerNode(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var node model.OpcUANode
+	if err := c.Bind(&node); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(node); err != nil {
+		return invalid(c, err)
+	}
+
+	if err := h.db.AddServerNode(param, node.NodeID); err != nil {
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.nodesChanged(param)
+
+	h.audit(c, auditServerNodeAdd, param, nil, node)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Remove a node from OPC UA Server, the node id is path escaped
+func (h *Handler) RemoveServerNode(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+	node, err := url.PathUnescape(c.Param("node"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.db.RemoveServerNode(param, node); err != nil {
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.nodesChanged(param)
+
+	h.audit(c, auditServerNodeRemove, param, model.OpcUANode{NodeID: node}, nil)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Send the saved nodes of the server to its live streams
+func (h *Handler) nodesChanged(name string) {
+	opc, err := h.db.GetServerByID(name)
+	if err != nil {
+		return
+	}
+	h.serverStreams.Update(h.streamKey(name), h.streamKey(name), opc)
+}
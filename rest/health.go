@@ -0,0 +1,221 @@
+package rest
+
+import (
+	"app/service"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrUNAVAILABLE = errors.New("Database unavailable, try again later")
+)
+
+// How often the database is pinged once connected
+const databaseCheckInterval = 5 * time.Second
+
+// Whether the database answered its last ping, shared by the tenant copies of the handler
+type readiness struct {
+	ready     int32
+	connected int32 // answered once since startup
+	stop      chan struct{}
+}
+
+func newReadiness() *readiness {
+	return &readiness{stop: make(chan struct{})}
+}
+
+// Record the result of a ping, logging when the database goes away or comes back
+func (r *readiness) update(err error) {
+	ready := int32(0)
+	if err == nil {
+		ready = 1
+		atomic.StoreInt32(&r.connected, 1)
+	}
+	if atomic.SwapInt32(&r.ready, ready) == ready {
+		return
+	}
+	if err == nil {
+		log.Print("MongoDB connected")
+	} else {
+		log.Printf("MongoDB unreachable: %v", err)
+	}
+}
+
+func (r *readiness) ok() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
+
+func (r *readiness) hasConnected() bool {
+	return atomic.LoadInt32(&r.connected) == 1
+}
+
+// Ping the database until stopped
+func (r *readiness) watch(ping func() error) {
+	ticker := time.NewTicker(databaseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.update(ping())
+		}
+	}
+}
+
+// Wait for the database to answer, retrying for up to timeout (0 to retry forever),
+// then keep pinging it. The handlers using it answer 503 while it doesn't answer.
+func (h *Handler) WaitForDatabase(timeout time.Duration) error {
+	if err := h.db.WaitForServer(timeout); err != nil {
+		return err
+	}
+	h.ready.update(nil)
+	go h.ready.watch(h.db.Ping)
+	h.createIndexes()
+	return nil
+}
+
+// Create the indexes of the default tenant and the other tenants. Failing
+// ones are logged, like duplicate e-mails from before the unique index.
+func (h *Handler) createIndexes() {
+	if err := h.db.CreateIndexes(); err != nil {
+		log.Printf("indexes: %v", err)
+	}
+	tenants, err := h.db.GetTenants()
+	if err != nil {
+		log.Printf("indexes: %v", err)
+		return
+	}
+	for _, tenant := range tenants {
+		if err := h.db.ForTenant(tenant.ID).CreateIndexes(); err != nil {
+			log.Printf("indexes of tenant %s: %v", tenant.ID, err)
+		}
+	}
+}
+
+// Close the websocket streams with a going away close frame, end the streams
+// and disconnect the database, giving up when the context is done
+func (h *Handler) Shutdown(ctx context.Context) error {
+	close(h.ready.stop)
+	h.conns.CloseAll(websocket.CloseGoingAway, "server shutting down")
+	err := service.Shutdown(ctx)
+	if err != nil {
+		log.Printf("streams still running at shutdown: %v", err)
+	}
+	if derr := h.db.Disconnect(ctx); derr != nil && err == nil {
+		err = derr
+	}
+	return err
+}
+
+// Refuse requests with 503 while the database is not reachable
+func (h *Handler) RequireDatabase(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !h.ready.ok() {
+			c.Response().Header().Set("Retry-After", "5")
+			return c.JSON(http.StatusServiceUnavailable, ErrUNAVAILABLE.Error())
+		}
+		return next(c)
+	}
+}
+
+// Result of a dependency check
+type healthCheck struct {
+	Status  string `json:"status"` // ok, connecting or down
+	Latency string `json:"latency,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status   string                 `json:"status"` // ok, degraded or unavailable
+	Checks   map[string]healthCheck `json:"checks"`
+	Sessions service.SessionStats   `json:"sessions"`
+	Servers  []service.ServerStatus `json:"servers"`
+}
+
+// Check the dependencies. The report is unavailable when the database or
+// ffmpeg can't be used, degraded when a streamed OPC UA server is in error.
+// Without ping, the database status is the one of the background pings.
+func (h *Handler) health(ping bool) healthReport {
+	report := healthReport{
+		Status:   "ok",
+		Checks:   map[string]healthCheck{},
+		Sessions: service.ActiveSessions(),
+		Servers:  service.ServerStatuses(),
+	}
+
+	mongo := healthCheck{Status: "ok"}
+	if !h.ready.hasConnected() {
+		mongo.Status = "connecting"
+	} else if !ping {
+		if !h.ready.ok() {
+			mongo.Status = "down"
+		}
+	} else {
+		start := time.Now()
+		err := h.db.Ping()
+		h.ready.update(err)
+		if err != nil {
+			mongo.Status = "down"
+			mongo.Error = err.Error()
+		}
+		mongo.Latency = time.Since(start).String()
+	}
+	report.Checks["mongo"] = mongo
+
+	ffmpeg := healthCheck{Status: "ok"}
+	path, err := service.FFmpegPath()
+	if err != nil {
+		ffmpeg.Status = "down"
+		ffmpeg.Error = err.Error()
+	}
+	ffmpeg.Path = path
+	report.Checks["ffmpeg"] = ffmpeg
+
+	for _, s := range report.Servers {
+		if s.LastError != "" {
+			report.Status = "degraded"
+		}
+	}
+	if mongo.Status != "ok" || ffmpeg.Status != "ok" {
+		report.Status = "unavailable"
+	}
+	return report
+}
+
+// Liveness, the process answers. Doesn't check the dependencies, so an
+// unreachable database doesn't get the process restarted.
+func (h *Handler) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readiness, 503 until the database and ffmpeg can be used. Unauthenticated,
+// so only the statuses are shown, the details are in Health, and the database
+// is not pinged for each probe.
+func (h *Handler) Readyz(c echo.Context) error {
+	report := h.health(false)
+	summary := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{report.Status, map[string]string{}}
+	for name, check := range report.Checks {
+		summary.Checks[name] = check.Status
+	}
+	if report.Status == "unavailable" {
+		return c.JSON(http.StatusServiceUnavailable, summary)
+	}
+	return c.JSON(http.StatusOK, summary)
+}
+
+// Dependencies, sessions and OPC UA servers of every tenant, with their errors
+func (h *Handler) Health(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.health(true))
+}
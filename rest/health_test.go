@@ -0,0 +1,70 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func (f *fakeDB) Ping() error {
+	f.pings++
+	return f.fail["Ping"]
+}
+
+func TestReadyzUsesBackgroundPings(t *testing.T) {
+	tests := []struct {
+		name  string
+		state func(r *readiness)
+		mongo string
+	}{
+		{"connecting", func(r *readiness) {}, "connecting"},
+		{"connected", func(r *readiness) { r.update(nil) }, "ok"},
+		{"gone away", func(r *readiness) { r.update(nil); r.update(errors.New("unreachable")) }, "down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeDB("")
+			h := testHandler(store)
+			tt.state(h.ready)
+
+			c, rec := testContext(http.MethodGet, "", nil, "")
+			if err := h.Readyz(c); err != nil {
+				t.Fatal(err)
+			}
+			var summary struct {
+				Checks map[string]string `json:"checks"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+				t.Fatal(err)
+			}
+			if got := summary.Checks["mongo"]; got != tt.mongo {
+				t.Errorf("mongo %s, want %s", got, tt.mongo)
+			}
+			if tt.mongo != "ok" && rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+			}
+			if store.pings != 0 {
+				t.Errorf("pinged %d times", store.pings)
+			}
+		})
+	}
+}
+
+func TestHealthPings(t *testing.T) {
+	store := newFakeDB("")
+	h := testHandler(store)
+	h.ready.update(nil)
+	store.fail["Ping"] = errors.New("unreachable")
+
+	c, _ := testContext(http.MethodGet, "", nil, "")
+	if err := h.Health(c); err != nil {
+		t.Fatal(err)
+	}
+	if store.pings != 1 {
+		t.Errorf("pinged %d times, want 1", store.pings)
+	}
+	if h.ready.ok() {
+		t.Error("failed ping not recorded")
+	}
+}
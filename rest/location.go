@@ -0,0 +1,186 @@
+package rest
+
+import (
+	"app/db"
+	"app/model"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Check the parent of an area is a site
+func (h *Handler) checkLocationParent(loc model.Location) error {
+	if loc.Kind != model.LocationArea {
+		return nil
+	}
+	parent, err := h.db.GetLocationByID(loc.Parent)
+	if err != nil || parent.Kind != model.LocationSite {
+		return model.ValidationErrors{{Field: "parent", Message: "unknown site: " + loc.Parent}}
+	}
+	return nil
+}
+
+// Get ALL Locations the user sees
+func (h *Handler) GetAllLocations(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	sites, err := h.userSites(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	locations, err := h.db.GetAllLocations()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	if len(sites) > 0 {
+		siteOfLocation := siteOf(locations)
+		visible := []model.Location{}
+		for _, loc := range locations {
+			if contains(sites, siteOfLocation[loc.ID]) {
+				visible = append(visible, loc)
+			}
+		}
+		locations = visible
+	}
+
+	return c.JSON(http.StatusOK, locations)
+}
+
+// Get a Location with the areas or gates under it
+func (h *Handler) GetLocationView(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	loc, err := h.db.GetLocationByID(param)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, db.ErrNOTFOUND.Error())
+	}
+	sites, err := h.userSites(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	site := loc.ID
+	if loc.Kind == model.LocationArea {
+		site = loc.Parent
+	}
+	if len(sites) > 0 && !contains(sites, site) {
+		return c.JSON(http.StatusForbidden, ErrFORBIDDEN.Error())
+	}
+
+	view := model.LocationView{Location: loc}
+	if loc.Kind == model.LocationSite {
+		locations, err := h.db.GetAllLocations()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		for _, area := range locations {
+			if area.Parent == loc.ID {
+				view.Areas = append(view.Areas, area)
+			}
+		}
+	} else {
+		gates, err := h.db.GetAllGates()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		for _, g := range gates {
+			if g.Location == loc.ID {
+				view.Gates = append(view.Gates, g)
+			}
+		}
+	}
+
+	return c.JSON(http.StatusOK, view)
+}
+
+// Add Location
+func (h *Handler) AddNewLocation(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	var loc model.Location
+	if err := c.Bind(&loc); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(loc); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkLocationParent(loc); err != nil {
+		return invalid(c, err)
+	}
+
+	if err := h.db.AddNewLocation(loc); err != nil {
+		if err == db.ErrINVALIDDATA {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditLocationAdd, loc.ID, nil, loc)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Rename a Location or move an area to another site
+func (h *Handler) PatchCurrentLocation(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var update model.LocationUpdate
+	if err := c.Bind(&update); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	before, err := h.db.GetLocationByID(param)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, db.ErrNOTFOUND.Error())
+	}
+	loc := update.Apply(before)
+	if err := c.Validate(loc); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkLocationParent(loc); err != nil {
+		return invalid(c, err)
+	}
+
+	if err := h.db.UpdateLocation(param, loc); err != nil {
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditLocationUpdate, param, before, loc)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Delete Location, which must be empty
+func (h *Handler) DeleteCurrentLocation(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	before, _ := h.db.GetLocationByID(param)
+	if err := h.db.DeleteLocation(param); err != nil {
+		if err == db.ErrINVALIDDATA {
+			return c.JSON(http.StatusForbidden, "location is not empty")
+		}
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.audit(c, auditLocationDelete, param, before, nil)
+
+	return c.JSON(http.StatusOK, "Success")
+}
@@ -0,0 +1,97 @@
+package rest
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrSIGNIN = errors.New("Invalid email or password")
+	ErrLOCKED = errors.New("Too many failed sign-in attempts, try again later")
+
+	maxAccountFailures = 5
+	maxAddressFailures = 20
+	failureWindow      = 15 * time.Minute
+	lockoutDuration    = 15 * time.Minute
+)
+
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func addressKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Time until which sign-ins are refused for any of the keys, zero when not locked
+func (h *Handler) lockedUntil(keys ...string) (time.Time, error) {
+	var until time.Time
+	for _, key := range keys {
+		attempt, err := h.db.GetLoginAttempt(key)
+		if err != nil {
+			return until, err
+		}
+		if attempt.LockedUntil.After(until) {
+			until = attempt.LockedUntil
+		}
+	}
+	if until.Before(time.Now()) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+// Count a failed sign-in for the account and the client address, and lock
+// whichever went over its limit.
+func (h *Handler) recordFailure(email, ip string) {
+	limits := map[string]int{
+		accountKey(email): maxAccountFailures,
+		addressKey(ip):    maxAddressFailures,
+	}
+	for key, max := range limits {
+		attempt, err := h.db.RecordLoginFailure(key, time.Now().Add(-failureWindow))
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if attempt.Failures >= max {
+			log.Printf("sign-in locked: %s failures=%d", key, attempt.Failures)
+			if err := h.db.LockLogin(key, time.Now().Add(lockoutDuration)); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+}
+
+func locked(c echo.Context, until time.Time) error {
+	retry := int(time.Until(until).Seconds()) + 1
+	c.Response().Header().Set("Retry-After", strconv.Itoa(retry))
+	return c.JSON(http.StatusTooManyRequests, ErrLOCKED.Error())
+}
+
+// Lift the sign-in lockout of an account (admin only)
+func (h *Handler) UnlockUser(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("email")
+
+	if err := h.db.ClearLoginAttempts(accountKey(param)); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if ip := c.QueryParam("ip"); ip != "" {
+		if err := h.db.ClearLoginAttempts(addressKey(ip)); err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	h.audit(c, auditAccountUnlock, param, nil, nil)
+
+	return c.JSON(http.StatusOK, "Success")
+}
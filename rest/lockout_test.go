@@ -0,0 +1,176 @@
+package rest
+
+import (
+	"app/db"
+	"app/model"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func (f *fakeDB) GetLoginAttempt(key string) (model.LoginAttempt, error) {
+	attempt, ok := f.attempts[key]
+	if !ok {
+		attempt.Key = key
+	}
+	return attempt, nil
+}
+
+func (f *fakeDB) RecordLoginFailure(key string, since time.Time) (model.LoginAttempt, error) {
+	attempt, _ := f.GetLoginAttempt(key)
+	if attempt.LastFailure.Before(since) {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailure = time.Now()
+	f.attempts[key] = attempt
+	return attempt, nil
+}
+
+func (f *fakeDB) LockLogin(key string, until time.Time) error {
+	attempt, _ := f.GetLoginAttempt(key)
+	attempt.Failures = 0
+	attempt.LockedUntil = until
+	f.attempts[key] = attempt
+	return nil
+}
+
+func (f *fakeDB) ClearLoginAttempts(key string) error {
+	delete(f.attempts, key)
+	return nil
+}
+
+func (f *fakeDB) CreateSession(session model.Session) error {
+	f.sessions[session.ID] = session
+	return nil
+}
+
+// Passwords by e-mail
+type fakeAuthenticator map[string]string
+
+func (a fakeAuthenticator) Authenticate(email, password string) (model.User, error) {
+	if p, ok := a[email]; ok && p == password {
+		return model.User{Email: email, Roles: []string{model.RoleViewer}}, nil
+	}
+	return model.User{}, db.ErrINVALIDPASSWORD
+}
+
+func (a fakeAuthenticator) LocalPasswords() bool {
+	return true
+}
+
+func lockoutHandler() (*Handler, *fakeDB) {
+	store := newFakeDB("")
+	h := testHandler(store)
+	h.authenticator = fakeAuthenticator{"erin@example.com": "erin1password", "fred@example.com": "fred1password"}
+	return h, store
+}
+
+func signIn(t *testing.T, h *Handler, email, password, ip string) int {
+	t.Helper()
+	c, rec := testContext(http.MethodPost, `{"email":"`+email+`","password":"`+password+`"}`, nil, "")
+	c.Request().RemoteAddr = ip + ":4321"
+	if err := h.SignIn(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+		t.Error("locked without Retry-After")
+	}
+	return rec.Code
+}
+
+func TestLockoutThreshold(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		status   int
+	}{
+		{"below the limit", maxAccountFailures - 1, http.StatusOK},
+		{"at the limit", maxAccountFailures, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		h, _ := lockoutHandler()
+		for i := 0; i < tt.failures; i++ {
+			if status := signIn(t, h, "erin@example.com", "wrong", "192.0.2.1"); status != http.StatusUnauthorized {
+				t.Fatalf("%s: failure %d answered %d", tt.name, i+1, status)
+			}
+		}
+		// the right password doesn't get through a lockout, from another address either
+		if status := signIn(t, h, "erin@example.com", "erin1password", "192.0.2.2"); status != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.status)
+		}
+		// other accounts are not locked
+		if status := signIn(t, h, "fred@example.com", "fred1password", "192.0.2.2"); status != http.StatusOK {
+			t.Errorf("%s: other account answered %d", tt.name, status)
+		}
+	}
+}
+
+func TestLockoutByAddress(t *testing.T) {
+	h, _ := lockoutHandler()
+	// a different account each time, so no account reaches its limit
+	for i := 0; i < maxAddressFailures; i++ {
+		signIn(t, h, fmt.Sprintf("nobody%d@example.com", i), "wrong", "192.0.2.1")
+	}
+	if status := signIn(t, h, "erin@example.com", "erin1password", "192.0.2.1"); status != http.StatusTooManyRequests {
+		t.Errorf("locked address answered %d", status)
+	}
+	if status := signIn(t, h, "erin@example.com", "erin1password", "192.0.2.2"); status != http.StatusOK {
+		t.Errorf("other address answered %d", status)
+	}
+}
+
+func TestLockoutWindowReset(t *testing.T) {
+	h, store := lockoutHandler()
+	key := accountKey("erin@example.com")
+	store.attempts[key] = model.LoginAttempt{
+		Key:         key,
+		Failures:    maxAccountFailures - 1,
+		LastFailure: time.Now().Add(-failureWindow - time.Minute),
+	}
+
+	// the old failures are forgotten, this one starts counting again
+	signIn(t, h, "erin@example.com", "wrong", "192.0.2.1")
+	if got := store.attempts[key].Failures; got != 1 {
+		t.Errorf("failures %d after the window, want 1", got)
+	}
+	if status := signIn(t, h, "erin@example.com", "erin1password", "192.0.2.1"); status != http.StatusOK {
+		t.Errorf("status %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	h, store := lockoutHandler()
+	for i := 0; i < maxAccountFailures; i++ {
+		signIn(t, h, "erin@example.com", "wrong", "192.0.2.1")
+	}
+	if status := signIn(t, h, "erin@example.com", "erin1password", "192.0.2.1"); status != http.StatusTooManyRequests {
+		t.Fatalf("not locked: %d", status)
+	}
+
+	admin := &Claims{Email: "admin@example.com", Roles: []string{model.RoleAdmin}, MFA: true}
+	c, rec := testContext(http.MethodPost, "", admin, "erin@example.com")
+	if err := h.UnlockUser(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unlock answered %d", rec.Code)
+	}
+	if _, ok := store.attempts[accountKey("erin@example.com")]; ok {
+		t.Error("attempts kept after unlock")
+	}
+	if status := signIn(t, h, "erin@example.com", "erin1password", "192.0.2.1"); status != http.StatusOK {
+		t.Errorf("status %d after unlock, want %d", status, http.StatusOK)
+	}
+}
+
+func TestLockExpires(t *testing.T) {
+	h, store := lockoutHandler()
+	key := accountKey("erin@example.com")
+	store.attempts[key] = model.LoginAttempt{Key: key, LockedUntil: time.Now().Add(-time.Second)}
+
+	if status := signIn(t, h, "erin@example.com", "erin1password", "192.0.2.1"); status != http.StatusOK {
+		t.Errorf("status %d after the lock expired, want %d", status, http.StatusOK)
+	}
+}
@@ -0,0 +1,258 @@
+package rest
+
+import (
+	"app/db"
+	"app/model"
+	"app/service"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Check the cameras and server the machine refers to exist, and that its
+// nodes are nodes of the server
+func (h *Handler) checkMachineRefs(machine model.Machine) error {
+	var v model.ValidationErrors
+	for _, cam := range machine.Cameras {
+		if _, err := h.db.GetCamByID(cam); err != nil {
+			v = append(v, model.FieldError{Field: "cameras", Message: "unknown camera: " + cam})
+		}
+	}
+	if machine.Server != "" {
+		if err := h.checkServerNodes("server", machine.Server, machine.NodeID); err != nil {
+			v = append(v, err.(model.ValidationErrors)...)
+		}
+	}
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// Resolve the references of the machine. Cameras and server deleted meanwhile are left out.
+func (h *Handler) machineView(machine model.Machine) model.MachineView {
+	view := model.MachineView{
+		ID:          machine.ID,
+		Description: machine.Description,
+		Asset:       machine.Asset,
+		Cameras:     []model.Camera{},
+	}
+	for _, name := range machine.Cameras {
+		if cam, err := h.db.GetCamByID(name); err == nil {
+			view.Cameras = append(view.Cameras, cam)
+		}
+	}
+	if machine.Server != "" {
+		if server, err := h.db.GetServerByID(machine.Server); err == nil {
+			server = onlyNodes(server, machine.NodeID)
+			view.Server = &server
+		}
+	}
+	return view
+}
+
+// Get ALL Machines
+func (h *Handler) GetAllMachines(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	s, err := h.requestScope(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	all, err := h.db.GetAllMachines()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	machines := []model.Machine{}
+	for _, machine := range all {
+		if s.machine(machine.ID) {
+			machines = append(machines, machine)
+		}
+	}
+
+	return c.JSON(http.StatusOK, machines)
+}
+
+// Get a Machine with its cameras and server
+func (h *Handler) GetMachineView(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+	s, err := h.requestScope(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if !s.machine(param) {
+		return c.JSON(http.StatusForbidden, ErrFORBIDDEN.Error())
+	}
+
+	machine, err := h.db.GetMachineByID(param)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, db.ErrNOTFOUND.Error())
+	}
+
+	return c.JSON(http.StatusOK, h.machineView(machine))
+}
+
+// Streaming the tag values of a Machine
+func (h *Handler) StreamMachine(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+	s, err := h.requestScope(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if !s.machine(param) {
+		return c.JSON(http.StatusForbidden, ErrFORBIDDEN.Error())
+	}
+
+	machine, err := h.db.GetMachineByID(param)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, db.ErrNOTFOUND.Error())
+	}
+	server, err := h.db.GetServerByID(machine.Server)
+	if err != nil || len(machine.NodeID) == 0 {
+		return c.JSON(http.StatusBadRequest, "machine has no nodes")
+	}
+
+	unSafeconn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+	ws := &service.ThreadSafeWriter{Conn: unSafeconn, Tenant: h.db.Tenant()}
+	session := currentClaims(c).Id
+	h.conns.Add(session, ws)
+	source := h.serverStreams.AddMachine(h.streamKey(server.Name), h.streamKey(machine.ID), server, machine.NodeID)
+	service.Go(func() {
+		defer h.conns.Remove(session, ws)
+		defer h.serverStreams.Remove(source)
+		ws.SetConfigurationAndRun(source)
+	})
+	h.audit(c, auditStreamOpcUA, server.Name, nil, nil)
+
+	return c.JSON(http.StatusOK, "Ready to stream")
+}
+
+// Add Machine
+func (h *Handler) AddNewMachine(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	var machine model.Machine
+	if err := c.Bind(&machine); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(machine); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkMachineRefs(machine); err != nil {
+		return invalid(c, err)
+	}
+
+	if err := h.db.AddNewMachine(machine); err != nil {
+		if err == db.ErrINVALIDDATA {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditMachineAdd, machine.ID, nil, machine)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Delete Machine
+func (h *Handler) DeleteCurrentMachine(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	before, _ := h.db.GetMachineByID(param)
+	if err := h.db.DeleteMachine(param); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.audit(c, auditMachineDelete, param, before, nil)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Replace Machine
+func (h *Handler) UpdateCurrentMachine(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var machine model.Machine
+	if err := c.Bind(&machine); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	return h.updateMachine(c, param, machine)
+}
+
+// Change some fields of Machine
+func (h *Handler) PatchCurrentMachine(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("id")
+
+	var update model.MachineUpdate
+	if err := c.Bind(&update); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	before, err := h.db.GetMachineByID(param)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, db.ErrNOTFOUND.Error())
+	}
+
+	return h.updateMachine(c, param, update.Apply(before))
+}
+
+func (h *Handler) updateMachine(c echo.Context, param string, machine model.Machine) error {
+	if err := c.Validate(machine); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkMachineRefs(machine); err != nil {
+		return invalid(c, err)
+	}
+
+	before, _ := h.db.GetMachineByID(param)
+	if err := h.db.UpdateMachine(param, machine); err != nil {
+		if err == db.ErrINVALIDDATA {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.updateMachineStreams(param, before, machine)
+
+	h.audit(c, auditMachineUpdate, param, before, machine)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Move the live streams of the machine to its new server and nodes. Without
+// a server they stay on the previous one, with no nodes.
+func (h *Handler) updateMachineStreams(param string, before, machine model.Machine) {
+	name, nodes := machine.Server, append([]string{}, machine.NodeID...)
+	if name == "" {
+		name, nodes = before.Server, []string{}
+	}
+	server, err := h.db.GetServerByID(name)
+	if err != nil {
+		return
+	}
+	h.serverStreams.UpdateMachine(h.streamKey(param), h.streamKey(machine.ID), h.streamKey(server.Name), server, nodes)
+}
@@ -0,0 +1,48 @@
+package rest
+
+import (
+	"app/metrics"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Record the latency and status of the requests by route. Echo reports the
+// request path as the route of requests matching none (the SPA files), they
+// are counted together.
+func measure(e *echo.Echo) echo.MiddlewareFunc {
+	var once sync.Once
+	routes := map[string]bool{}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			once.Do(func() {
+				for _, r := range e.Routes() {
+					routes[r.Path] = true
+				}
+			})
+
+			start := time.Now()
+			err := next(c)
+
+			status := c.Response().Status
+			if err != nil {
+				status = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				}
+			}
+			route := c.Path()
+			if !routes[route] {
+				route = "unmatched"
+			}
+			metrics.HTTPRequestDuration.
+				WithLabelValues(c.Request().Method, route, strconv.Itoa(status)).
+				Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
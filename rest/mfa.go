@@ -0,0 +1,183 @@
+package rest
+
+import (
+	"app/db"
+	"app/model"
+	"app/service"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	totpIssuer         = "Monitoring"
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+type signInRequest struct {
+	Tenant   string `json:"tenant"` // empty for the default tenant
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// second step, when the first one answered mfa_required
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"` // TOTP code or recovery code
+}
+
+type mfaResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	Expires     int64  `json:"expires"`
+}
+
+// Finish signing in with a TOTP or recovery code
+func (h *Handler) signInSecondFactor(c echo.Context, body signInRequest) error {
+	claims, err := parseToken(body.MFAToken, mfaAudience)
+	if err != nil || claims.Id == "" {
+		return unauthorized(c)
+	}
+	h = h.forTenant(claims.Tenant)
+
+	until, err := h.lockedUntil(accountKey(claims.Email), addressKey(c.RealIP()))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if !until.IsZero() {
+		return locked(c, until)
+	}
+
+	user, err := h.db.GetUserByEmail(claims.Email)
+	if err != nil || user.Disabled || !user.TOTPEnabled {
+		return unauthorized(c)
+	}
+
+	// a TOTP code is accepted once, a recovery code is removed when used
+	code := strings.TrimSpace(body.Code)
+	if step, ok := service.MatchTOTP(user.TOTPSecret, code, time.Now()); ok {
+		err = h.db.UseTOTPStep(user.Email, step)
+	} else {
+		err = h.db.UseRecoveryCode(user.Email, code)
+	}
+	if err != nil {
+		h.recordFailure(user.Email, c.RealIP())
+		h.auditAs(c, user.Email, auditSignInFailed, user.Email, nil, map[string]string{"method": "totp"})
+		return c.JSON(http.StatusUnauthorized, "Invalid code")
+	}
+	// the password step can't be replayed for another session
+	if err := h.db.UseMFAToken(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		if err == db.ErrINVALIDPASSWORD {
+			return unauthorized(c)
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if err := h.db.ClearLoginAttempts(accountKey(user.Email)); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	resp, err := h.startSession(user, true)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.auditAs(c, user.Email, auditSignIn, user.Email, nil, map[string]string{"method": "totp"})
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// Start enrolling an authenticator app for the signed in user
+func (h *Handler) EnrollTOTP(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	email := currentClaims(c).Email
+
+	user, err := h.db.GetUserByEmail(email)
+	if err != nil {
+		return unauthorized(c)
+	}
+	if user.TOTPEnabled {
+		return c.JSON(http.StatusConflict, "two-factor authentication is already enabled")
+	}
+
+	secret, err := service.GenerateTOTPSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if err := h.db.SetTOTPSecret(email, secret); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"secret": secret,
+		"uri":    service.TOTPProvisioningURI(totpIssuer, email, secret),
+	})
+}
+
+// Enable two-factor authentication with a first code from the authenticator app.
+// The recovery codes are only shown once.
+func (h *Handler) ConfirmTOTP(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	email := currentClaims(c).Email
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	user, err := h.db.GetUserByEmail(email)
+	if err != nil {
+		return unauthorized(c)
+	}
+	if user.TOTPEnabled {
+		return c.JSON(http.StatusConflict, "two-factor authentication is already enabled")
+	}
+	step, ok := service.MatchTOTP(user.TOTPSecret, strings.TrimSpace(body.Code), time.Now())
+	if user.TOTPSecret == "" || !ok {
+		return invalid(c, model.ValidationErrors{{Field: "code", Message: "invalid code"}})
+	}
+	// the enrollment code can't be used again to sign in
+	if err := h.db.UseTOTPStep(email, step); err != nil {
+		return invalid(c, model.ValidationErrors{{Field: "code", Message: "invalid code"}})
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := randomToken(recoveryCodeLength)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		codes[i] = code[:recoveryCodeLength]
+	}
+	if err := h.db.EnableTOTP(email, codes); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// Remove the two-factor authentication of a user who lost their device (admin only)
+func (h *Handler) ResetUserTOTP(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("email")
+
+	if err := h.db.ResetTOTP(param); err != nil {
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if err := h.revokeUser(param, "two-factor authentication reset"); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditTOTPReset, param, nil, nil)
+
+	return c.JSON(http.StatusOK, "Success")
+}
@@ -0,0 +1,148 @@
+package rest
+
+import (
+	"app/db"
+	"app/model"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+func (f *fakeDB) GetUserByEmail(email string) (model.User, error) {
+	user, ok := f.users[email]
+	if !ok {
+		return user, db.ErrNOTFOUND
+	}
+	return user, nil
+}
+
+func (f *fakeDB) UseTOTPStep(email string, step int64) error {
+	user := f.users[email]
+	if step <= user.TOTPStep {
+		return db.ErrINVALIDPASSWORD
+	}
+	user.TOTPStep = step
+	f.users[email] = user
+	return nil
+}
+
+// The fake keeps the recovery codes in clear
+func (f *fakeDB) UseRecoveryCode(email, code string) error {
+	user := f.users[email]
+	for i, c := range user.RecoveryCodes {
+		if c == code {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			f.users[email] = user
+			return nil
+		}
+	}
+	return db.ErrINVALIDPASSWORD
+}
+
+func (f *fakeDB) UseMFAToken(id string, expires time.Time) error {
+	if f.used[id] {
+		return db.ErrINVALIDPASSWORD
+	}
+	f.used[id] = true
+	return nil
+}
+
+// Current code of the secret, as an authenticator app shows it
+func currentTOTP(t *testing.T, secret string) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func mfaHandler() (*Handler, *fakeDB, model.User) {
+	store := newFakeDB("")
+	user := model.User{
+		Email:         "erin@example.com",
+		Roles:         []string{model.RoleAdmin},
+		TOTPSecret:    testTOTPSecret,
+		TOTPEnabled:   true,
+		RecoveryCodes: []string{"recovery01", "recovery02"},
+	}
+	store.users[user.Email] = user
+	return testHandler(store), store, user
+}
+
+func secondFactor(t *testing.T, h *Handler, user model.User, token, code string) int {
+	t.Helper()
+	if token == "" {
+		var err error
+		if token, _, err = issueMFAToken(user, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, rec := testContext(http.MethodPost, `{"mfa_token":"`+token+`","code":"`+code+`"}`, nil, "")
+	if err := h.SignIn(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code
+}
+
+func TestSecondFactorRefusesReplayedCode(t *testing.T) {
+	h, _, user := mfaHandler()
+	code := currentTOTP(t, testTOTPSecret)
+
+	if status := secondFactor(t, h, user, "", code); status != http.StatusOK {
+		t.Fatalf("first use answered %d", status)
+	}
+	// a new password step doesn't make the code valid again
+	if status := secondFactor(t, h, user, "", code); status != http.StatusUnauthorized {
+		t.Errorf("replayed code answered %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestSecondFactorRefusesReusedToken(t *testing.T) {
+	h, _, user := mfaHandler()
+	token, _, err := issueMFAToken(user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := secondFactor(t, h, user, token, "recovery01"); status != http.StatusOK {
+		t.Fatalf("first use answered %d", status)
+	}
+	if status := secondFactor(t, h, user, token, "recovery02"); status != http.StatusUnauthorized {
+		t.Errorf("reused token answered %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestSecondFactorRecoveryCodes(t *testing.T) {
+	h, store, user := mfaHandler()
+
+	tests := []struct {
+		code   string
+		status int
+	}{
+		{"recovery01", http.StatusOK},
+		{"recovery01", http.StatusUnauthorized}, // used
+		{"recovery03", http.StatusUnauthorized},
+		{"recovery02", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if status := secondFactor(t, h, user, "", tt.code); status != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.code, status, tt.status)
+		}
+	}
+	if codes := store.users[user.Email].RecoveryCodes; len(codes) != 0 {
+		t.Errorf("recovery codes left: %v", codes)
+	}
+}
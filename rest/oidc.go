@@ -0,0 +1,129 @@
+package rest
+
+import (
+	"app/auth"
+	"app/db"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+	oidcAudience    = "oidc"
+)
+
+// Login attempt kept in a signed cookie between the redirect and the callback
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.StandardClaims
+}
+
+// Redirect to the identity provider login page
+func (h *Handler) OIDCLogin(c echo.Context) error {
+	if h.oidc == nil {
+		return c.JSON(http.StatusNotFound, "OIDC is not configured")
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := randomToken(32)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		values[i] = v
+	}
+	state := &oidcState{State: values[0], Nonce: values[1], Verifier: values[2]}
+	state.Audience = oidcAudience
+	state.ExpiresAt = time.Now().Add(oidcStateTTL).Unix()
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, state).SignedString(jwtSecret)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	redirect, err := h.oidc.AuthCodeURL(state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		log.Print(err)
+		return c.JSON(http.StatusBadGateway, "identity provider unavailable")
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    signed,
+		Path:     "/user/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   c.IsTLS(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, redirect)
+}
+
+// Finish the login: check the state, exchange the code, verify the ID token,
+// provision the user and hand the tokens to the SPA in the URL fragment.
+func (h *Handler) OIDCCallback(c echo.Context) error {
+	if h.oidc == nil {
+		return c.JSON(http.StatusNotFound, "OIDC is not configured")
+	}
+	if e := c.QueryParam("error"); e != "" {
+		return c.JSON(http.StatusUnauthorized, e)
+	}
+
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		return unauthorized(c)
+	}
+	c.SetCookie(&http.Cookie{Name: oidcStateCookie, Path: "/user/oidc", MaxAge: -1})
+
+	state := &oidcState{}
+	token, err := jwt.ParseWithClaims(cookie.Value, state, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrUNAUTHORIZED
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid || !state.VerifyAudience(oidcAudience, true) || state.State != c.QueryParam("state") {
+		return unauthorized(c)
+	}
+
+	raw, err := h.oidc.Exchange(c.QueryParam("code"), state.Verifier)
+	if err != nil {
+		log.Print(err)
+		return unauthorized(c)
+	}
+	claims, err := h.oidc.Verify(raw, state.Nonce)
+	if err != nil {
+		log.Print(err)
+		return unauthorized(c)
+	}
+	user, err := h.oidc.Provision(claims)
+	if err != nil {
+		if err == db.ErrDISABLED || err == db.ErrSOURCE || err == auth.ErrNOROLE {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		if err == auth.ErrIDTOKEN {
+			return unauthorized(c)
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	resp, err := h.startSession(user, auth.MultiFactor(claims))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	h.auditAs(c, user.Email, auditSignIn, user.Email, nil, map[string]string{"method": "oidc"})
+
+	fragment := url.Values{}
+	fragment.Set("token", resp.Token)
+	fragment.Set("expires", strconv.FormatInt(resp.Expires, 10))
+	fragment.Set("refresh_token", resp.RefreshToken)
+	return c.Redirect(http.StatusFound, h.oidc.PostLoginURL+"#"+fragment.Encode())
+}
@@ -1,66 +1,235 @@
 package rest
 
 import (
+	"app/config"
+	"app/model"
+	"app/service"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func RunAPIWithHandler() {
+// Serve the API until SIGINT or SIGTERM, the server fails or the database
+// stays unreachable past its startup timeout. The SPA and health endpoints
+// are served while the database is being reached, the API answers 503 until
+// then. Shutting down stops accepting connections, then ends the streams
+// and disconnects the database within the shutdown timeout.
+func RunAPIWithHandler(cfg config.Config) error {
 	e := echo.New()
+	e.Validator = bodyValidator{}
+	e.IPExtractor = ipExtractor(cfg.HTTP.TrustedProxies)
+	service.FFmpeg = cfg.FFmpeg
+	if cfg.Auth.JWTSecret != "" {
+		jwtSecret = []byte(cfg.Auth.JWTSecret)
+	}
+	upgrader.CheckOrigin = checkOrigin(cfg.Security.CORSOrigins)
 
 	// Handler
-	h, err := NewHandler()
+	h, err := NewHandler(cfg)
 	if err != nil {
-		return
+		return fmt.Errorf("setup failed: %v", err)
 	}
 
 	// Middleware
+	e.Use(measure(e))
 	e.Use(middleware.Logger()) // Log all request log
 	e.Use(middleware.Recover())
+	e.Use(securityHeaders(cfg.Security, cfg.TLS.Enabled()))
 
 	//CORS Middleware
-	// e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-	// 	AllowOrigins: []string{"*"},
-	// 	AllowMethods: []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete},
-	// }))
+	e.Use(cors(cfg.Security))
 
 	// Serve SPA app
 	// This serves static files from "Path" directory and enables directory browing.
 	// Default behavior when using with non root URL(refresh, reload etc) paths is to append the URL path to filesystem path
 	// For example, when an incoming request comes for '/somepath' the actual filesystem request goes to 'filesystempath/somepath instead of only 'filesystempath'
 	e.Use(middleware.StaticWithConfig(middleware.StaticConfig{
-		Root:   "build",
+		Root:   cfg.HTTP.StaticDir,
 		Index:  "index.html",
 		Browse: false,
 		HTML5:  true,
 	}))
 
+	// Health
+	e.GET("/healthz", h.Healthz)
+	e.GET("/readyz", h.Readyz)
+
 	// Router
-	user := e.Group("/user")
+	online := h.RequireDatabase
+	admin := h.RequireRole(model.RoleAdmin)
+	e.GET("/health", h.Health, online, h.Authenticate, h.RequireRole(model.RoleSuperAdmin))
+	// the series name the tenants, cameras and servers, so only super-admins
+	// see them here, Prometheus scrapes the metrics address
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()), online, h.Authenticate, h.RequireRole(model.RoleSuperAdmin))
+	// authenticated handlers work on the data of the user's tenant
+	t := h.Tenant
+
+	user := e.Group("/user", online)
 	{
 		user.POST("/signin", h.SignIn)
+		user.GET("/oidc/login", h.OIDCLogin)
+		user.GET("/oidc/callback", h.OIDCCallback)
 		user.POST("/signup", h.SignUp)
+		user.POST("/refresh", h.Refresh)
+		user.POST("/signout", t(HandlerInterface.SignOut), h.Authenticate)
+		user.POST("/password", t(HandlerInterface.ChangePassword), h.Authenticate)
+		user.POST("/password/forgot", h.ForgotPassword)
+		user.POST("/password/reset", h.ResetPassword)
+		user.POST("/2fa", t(HandlerInterface.EnrollTOTP), h.Authenticate)
+		user.POST("/2fa/confirm", t(HandlerInterface.ConfirmTOTP), h.Authenticate)
+		user.POST("/apikeys", t(HandlerInterface.CreateAPIKey), h.Authenticate)
+		user.GET("/apikeys", t(HandlerInterface.GetAPIKeys), h.Authenticate)
+		user.GET("/apikeys/all", t(HandlerInterface.GetAllAPIKeys), h.Authenticate, admin)
+		user.DELETE("/apikeys/:id", t(HandlerInterface.RevokeAPIKey), h.Authenticate)
+		user.PUT("/:email/roles", t(HandlerInterface.UpdateUserRoles), h.Authenticate, admin)
+		user.DELETE("/:email/sessions", t(HandlerInterface.RevokeUserSessions), h.Authenticate, admin)
+		// administration
+		user.GET("", t(HandlerInterface.GetUsers), h.Authenticate, admin)
+		user.POST("", t(HandlerInterface.CreateUser), h.Authenticate, admin)
+		user.GET("/:email", t(HandlerInterface.GetUser), h.Authenticate, admin)
+		user.PATCH("/:email", t(HandlerInterface.UpdateUser), h.Authenticate, admin)
+		user.POST("/:email/disable", t(HandlerInterface.DisableUser), h.Authenticate, admin)
+		user.POST("/:email/enable", t(HandlerInterface.EnableUser), h.Authenticate, admin)
+		user.POST("/:email/unlock", t(HandlerInterface.UnlockUser), h.Authenticate, admin)
+		user.DELETE("/:email/2fa", t(HandlerInterface.ResetUserTOTP), h.Authenticate, admin)
+		user.DELETE("/:email", t(HandlerInterface.DeleteUser), h.Authenticate, admin)
+	}
+
+	monitor := e.Group("/monitor", online, h.Authenticate, h.RequireRole(model.RoleViewer))
+	{
+		monitor.GET("/cams", t(HandlerInterface.GetAllCam))
+		monitor.POST("/cams", t(HandlerInterface.AddNewCam), admin)
+		monitor.GET("/cams/:id", t(HandlerInterface.GetCurrentCam))
+		monitor.DELETE("/cams/:id", t(HandlerInterface.DeleteCurrentCam), admin)
+		monitor.PUT("/cams/:id", t(HandlerInterface.UpdateCurrentCam), admin)
+		monitor.PATCH("/cams/:id", t(HandlerInterface.PatchCurrentCam), admin)
+		monitor.GET("/stream/:id", t(HandlerInterface.StreamRTSP))
+
+	}
+
+	opcua := e.Group("/server", online, h.Authenticate, h.RequireRole(model.RoleViewer))
+	{
+		opcua.GET("/stream/:id", t(HandlerInterface.MonitoringOpcUA))
+		opcua.GET("/client", t(HandlerInterface.GetAllServer))
+		opcua.POST("/client", t(HandlerInterface.AddNewServer), admin)
+		opcua.GET("/client/:id", t(HandlerInterface.GetCurrentServer))
+		opcua.DELETE("/client/:id", t(HandlerInterface.DeleteCurrentServer), admin)
+		opcua.PUT("/client/:id", t(HandlerInterface.UpdateCurrentServer), admin)
+		opcua.PATCH("/client/:id", t(HandlerInterface.PatchCurrentServer), admin)
+		opcua.POST("/client/:id/nodes", t(HandlerInterface.AddServerNode), admin)
+		opcua.DELETE("/client/:id/nodes/:node", t(HandlerInterface.RemoveServerNode), admin)
+	}
+
+	gate := e.Group("/gates", online, h.Authenticate, h.RequireRole(model.RoleViewer))
+	{
+		gate.GET("", t(HandlerInterface.GetAllGates))
+		gate.POST("", t(HandlerInterface.AddNewGate), admin)
+		gate.GET("/:id", t(HandlerInterface.GetGateView))
+		gate.DELETE("/:id", t(HandlerInterface.DeleteCurrentGate), admin)
+		gate.PUT("/:id", t(HandlerInterface.UpdateCurrentGate), admin)
+		gate.PATCH("/:id", t(HandlerInterface.PatchCurrentGate), admin)
+		gate.POST("/:id/cams", t(HandlerInterface.AttachGateCam), admin)
+		gate.DELETE("/:id/cams/:cam", t(HandlerInterface.DetachGateCam), admin)
+		gate.POST("/:id/servers", t(HandlerInterface.AttachGateServer), admin)
+		gate.DELETE("/:id/servers/:server", t(HandlerInterface.DetachGateServer), admin)
 	}
 
-	monitor := e.Group("/monitor")
+	machine := e.Group("/machines", online, h.Authenticate, h.RequireRole(model.RoleViewer))
 	{
-		monitor.GET("/cams", h.GetAllCam)
-		monitor.POST("/cams", h.AddNewCam)
-		monitor.GET("/cams/:id", h.GetCurrentCam)
-		monitor.DELETE("/cams/:id", h.DeleteCurrentCam)
-		monitor.GET("/stream/:id", h.StreamRTSP)
+		machine.GET("", t(HandlerInterface.GetAllMachines))
+		machine.POST("", t(HandlerInterface.AddNewMachine), admin)
+		machine.GET("/:id", t(HandlerInterface.GetMachineView))
+		machine.GET("/:id/stream", t(HandlerInterface.StreamMachine))
+		machine.DELETE("/:id", t(HandlerInterface.DeleteCurrentMachine), admin)
+		machine.PUT("/:id", t(HandlerInterface.UpdateCurrentMachine), admin)
+		machine.PATCH("/:id", t(HandlerInterface.PatchCurrentMachine), admin)
+	}
 
+	location := e.Group("/locations", online, h.Authenticate, h.RequireRole(model.RoleViewer))
+	{
+		location.GET("", t(HandlerInterface.GetAllLocations))
+		location.POST("", t(HandlerInterface.AddNewLocation), admin)
+		location.GET("/:id", t(HandlerInterface.GetLocationView))
+		location.PATCH("/:id", t(HandlerInterface.PatchCurrentLocation), admin)
+		location.DELETE("/:id", t(HandlerInterface.DeleteCurrentLocation), admin)
 	}
 
-	opcua := e.Group("/server")
+	e.GET("/audit", t(HandlerInterface.GetAudit), online, h.Authenticate, admin)
+
+	tenant := e.Group("/tenants", online, h.Authenticate, h.RequireRole(model.RoleSuperAdmin))
 	{
-		// opcua.GET("/stream/:id", h.MonitoringOpcUA) //-> client/:id랑 병합
-		opcua.GET("/client", h.GetAllServer)
-		opcua.POST("/client", h.AddNewServer)
-		opcua.GET("/client/:id", h.GetCurrentServer)
-		opcua.DELETE("/client/:id", h.DeleteCurrentServer)
+		tenant.GET("", h.GetTenants)
+		tenant.POST("", h.CreateTenant)
 	}
 
-	// Start server
-	e.Logger.Fatal(e.Start(":5000"))
+	// Start server, reaching the database meanwhile
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	errs := make(chan error, 4)
+	var redirect, metricsServer *http.Server
+	if cfg.HTTP.MetricsAddress != "" {
+		metricsServer = &http.Server{Addr: cfg.HTTP.MetricsAddress, Handler: promhttp.Handler()}
+		go func() {
+			errs <- metricsServer.ListenAndServe()
+		}()
+	}
+	if cfg.TLS.Enabled() {
+		files, err := newTLSFiles(cfg.TLS)
+		if err != nil {
+			return err
+		}
+		watchCtx, stopWatch := context.WithCancel(context.Background())
+		defer stopWatch()
+		go files.watch(watchCtx)
+
+		e.TLSServer.Addr = cfg.HTTP.Address
+		e.TLSServer.TLSConfig = files.tlsConfig()
+		go func() {
+			errs <- e.StartServer(e.TLSServer)
+		}()
+		if cfg.TLS.RedirectAddress != "" {
+			redirect = &http.Server{Addr: cfg.TLS.RedirectAddress, Handler: redirectToHTTPS(cfg.HTTP.Address)}
+			go func() {
+				errs <- redirect.ListenAndServe()
+			}()
+		}
+	} else {
+		go func() {
+			errs <- e.Start(cfg.HTTP.Address)
+		}()
+	}
+	go func() {
+		if err := h.WaitForDatabase(cfg.Mongo.StartupTimeoutDuration()); err != nil {
+			errs <- err
+		}
+	}()
+	select {
+	case err = <-errs:
+	case sig := <-quit:
+		log.Printf("%s received, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeoutDuration())
+	defer cancel()
+	if serr := e.Shutdown(ctx); serr != nil {
+		log.Printf("HTTP shutdown: %v", serr)
+	}
+	if redirect != nil {
+		redirect.Shutdown(ctx)
+	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(ctx)
+	}
+	if serr := h.Shutdown(ctx); serr != nil {
+		log.Printf("shutdown: %v", serr)
+	}
+	return err
 }
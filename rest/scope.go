@@ -0,0 +1,161 @@
+package rest
+
+import (
+	"app/model"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Gates a request may see, with the machines, cameras and servers attached
+// to them. A nil scope sees everything.
+type scope struct {
+	gates    map[string]bool
+	machines map[string]bool
+	cameras  map[string]bool
+	servers  map[string]bool
+	nodes    map[string]map[string]bool // of each server, nil for every node
+}
+
+func (s *scope) gate(name string) bool   { return s == nil || s.gates[name] }
+func (s *scope) machine(id string) bool  { return s == nil || s.machines[id] }
+func (s *scope) camera(name string) bool { return s == nil || s.cameras[name] }
+func (s *scope) server(name string) bool { return s == nil || s.servers[name] }
+
+// Nodes of a server the scope sees, nil for every node
+func (s *scope) serverNodes(name string) []string {
+	if s == nil {
+		return nil
+	}
+	nodes, ok := s.nodes[name]
+	if ok && nodes == nil {
+		return nil
+	}
+	ids := []string{}
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Let the scope see some nodes of a server, every node for nil
+func (s *scope) addNodes(server string, ids []string) {
+	s.servers[server] = true
+	nodes, ok := s.nodes[server]
+	if ok && nodes == nil {
+		return
+	}
+	if ids == nil {
+		s.nodes[server] = nil
+		return
+	}
+	if nodes == nil {
+		nodes = map[string]bool{}
+		s.nodes[server] = nodes
+	}
+	for _, id := range ids {
+		nodes[id] = true
+	}
+}
+
+// Sites the signed in user is restricted to, none when they see every site
+func (h *Handler) userSites(c echo.Context) ([]string, error) {
+	user, err := h.db.GetUserByEmail(currentClaims(c).Email)
+	if err != nil {
+		return nil, err
+	}
+	return user.Sites, nil
+}
+
+// Site of every location, a site being its own site
+func siteOf(locations []model.Location) map[string]string {
+	sites := map[string]string{}
+	for _, loc := range locations {
+		if loc.Kind == model.LocationSite {
+			sites[loc.ID] = loc.ID
+		} else {
+			sites[loc.ID] = loc.Parent
+		}
+	}
+	return sites
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Scope of the request: the sites of the user, narrowed by the site, area
+// and gate query parameters
+func (h *Handler) requestScope(c echo.Context) (*scope, error) {
+	sites, err := h.userSites(c)
+	if err != nil {
+		return nil, err
+	}
+	site, area, gate := c.QueryParam("site"), c.QueryParam("area"), c.QueryParam("gate")
+	if len(sites) == 0 && site == "" && area == "" && gate == "" {
+		return nil, nil
+	}
+
+	locations, err := h.db.GetAllLocations()
+	if err != nil {
+		return nil, err
+	}
+	gates, err := h.db.GetAllGates()
+	if err != nil {
+		return nil, err
+	}
+	machines, err := h.db.GetAllMachines()
+	if err != nil {
+		return nil, err
+	}
+	siteOfLocation := siteOf(locations)
+	machineByID := map[string]model.Machine{}
+	for _, m := range machines {
+		machineByID[m.ID] = m
+	}
+
+	s := &scope{
+		gates:    map[string]bool{},
+		machines: map[string]bool{},
+		cameras:  map[string]bool{},
+		servers:  map[string]bool{},
+		nodes:    map[string]map[string]bool{},
+	}
+	for _, g := range gates {
+		gateSite := siteOfLocation[g.Location]
+		if (len(sites) > 0 && !contains(sites, gateSite)) ||
+			(site != "" && gateSite != site) ||
+			(area != "" && g.Location != area) ||
+			(gate != "" && g.Name != gate) {
+			continue
+		}
+		s.gates[g.Name] = true
+		for _, cam := range g.Cameras {
+			s.cameras[cam] = true
+		}
+		for _, server := range g.Servers {
+			if len(server.NodeID) == 0 {
+				s.addNodes(server.Server, nil)
+			} else {
+				s.addNodes(server.Server, server.NodeID)
+			}
+		}
+		for _, id := range g.Machine {
+			s.machines[id] = true
+			m := machineByID[id]
+			for _, cam := range m.Cameras {
+				s.cameras[cam] = true
+			}
+			if m.Server != "" {
+				s.addNodes(m.Server, append([]string{}, m.NodeID...))
+			}
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,68 @@
+package rest
+
+import (
+	"app/model"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// Locations, gates and machines of a tenant
+type plant struct {
+	locations []model.Location
+	gates     []model.Gate
+	machines  []model.Machine
+}
+
+func (f *fakeDB) GetAllLocations() ([]model.Location, error) { return f.plant.locations, nil }
+func (f *fakeDB) GetAllGates() ([]model.Gate, error)         { return f.plant.gates, nil }
+func (f *fakeDB) GetAllMachines() ([]model.Machine, error)   { return f.plant.machines, nil }
+
+func TestServerNodesInScope(t *testing.T) {
+	store := newFakeDB("")
+	store.plant = plant{
+		locations: []model.Location{
+			{ID: "north", Kind: model.LocationSite},
+			{ID: "south", Kind: model.LocationSite},
+		},
+		gates: []model.Gate{
+			{Name: "n1", Location: "north", Machine: []string{"press"}, Servers: []model.GateServer{{Server: "plc", NodeID: []string{"ns=1;s=door"}}}},
+			{Name: "n2", Location: "north", Servers: []model.GateServer{{Server: "hmi"}}},
+			{Name: "s1", Location: "south", Machine: []string{"lathe"}, Servers: []model.GateServer{{Server: "plc"}}},
+		},
+		machines: []model.Machine{
+			{ID: "press", Server: "plc", NodeID: []string{"ns=1;s=speed", "ns=1;s=door"}},
+			{ID: "lathe", Server: "plc", NodeID: []string{"ns=1;s=spindle"}},
+		},
+	}
+	h := testHandler(store)
+
+	tests := []struct {
+		name   string
+		sites  []string
+		server string
+		nodes  []string
+	}{
+		{"every site", nil, "plc", nil},
+		{"gate and machine nodes", []string{"north"}, "plc", []string{"ns=1;s=door", "ns=1;s=speed"}},
+		{"gate with every node", []string{"south"}, "plc", nil},
+		{"server of a gate without nodes", []string{"north"}, "hmi", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.users["erin@example.com"] = model.User{Email: "erin@example.com", Sites: tt.sites}
+			c, _ := testContext(http.MethodGet, "", &Claims{Email: "erin@example.com"}, "")
+
+			s, err := h.requestScope(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !s.server(tt.server) {
+				t.Fatalf("%s out of scope", tt.server)
+			}
+			if nodes := s.serverNodes(tt.server); !reflect.DeepEqual(nodes, tt.nodes) {
+				t.Errorf("nodes %v, want %v", nodes, tt.nodes)
+			}
+		})
+	}
+}
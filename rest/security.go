@@ -0,0 +1,83 @@
+package rest
+
+import (
+	"app/config"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// Cross-origin access for the configured origins only, none when there is none
+func cors(cfg config.Security) echo.MiddlewareFunc {
+	if len(cfg.CORSOrigins) == 0 {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins: cfg.CORSOrigins,
+		AllowMethods: cfg.CORSMethods,
+		AllowHeaders: []string{echo.HeaderAuthorization, echo.HeaderContentType, apiKeyHeader},
+		MaxAge:       600,
+	})
+}
+
+// Content security policy, frame options and, over HTTPS, HSTS
+func securityHeaders(cfg config.Security, tls bool) echo.MiddlewareFunc {
+	hsts := 0
+	if tls {
+		hsts = cfg.HSTSMaxAge
+	}
+	return middleware.SecureWithConfig(middleware.SecureConfig{
+		XSSProtection:         "1; mode=block",
+		ContentTypeNosniff:    "nosniff",
+		XFrameOptions:         cfg.FrameOptions,
+		HSTSMaxAge:            hsts,
+		HSTSExcludeSubdomains: true,
+		ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+		ReferrerPolicy:        "same-origin",
+	})
+}
+
+// Accept websocket upgrades from pages of this server or of the allowed
+// origins. Requests without Origin don't come from browsers, they are
+// authenticated by their token like the other API clients.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Client address of the requests, for the sign-in lockout and the audit log.
+// X-Forwarded-For is only believed when it comes from a trusted proxy,
+// clients could otherwise pick any address.
+func ipExtractor(proxies []string) echo.IPExtractor {
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range proxies {
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			options = append(options, echo.TrustIPRange(ipNet))
+		}
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
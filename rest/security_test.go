@@ -0,0 +1,29 @@
+package rest
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPExtractorIgnoresSpoofedHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		remote  string
+		want    string
+	}{
+		{"no proxy", nil, "203.0.113.7:4321", "203.0.113.7"},
+		{"private client without proxies", nil, "10.0.0.5:4321", "10.0.0.5"},
+		{"trusted proxy", []string{"10.0.0.0/24"}, "10.0.0.5:4321", "198.51.100.9"},
+		{"untrusted proxy", []string{"10.0.0.0/24"}, "203.0.113.7:4321", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/user/signin", nil)
+		req.RemoteAddr = tt.remote
+		req.Header.Set("X-Forwarded-For", "192.0.2.1, 198.51.100.9")
+		req.Header.Set("X-Real-IP", "192.0.2.1")
+		if got := ipExtractor(tt.proxies)(req); got != tt.want {
+			t.Errorf("%s: client address %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package rest
+
+import (
+	"app/db"
+	"app/model"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// tenant IDs are part of the tenant database name
+var tenantPattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// Request body to create a tenant with its first admin
+type tenantRequest struct {
+	ID    string       `json:"id"`
+	Name  string       `json:"name"`
+	Admin model.SignUp `json:"admin"`
+}
+
+func (r tenantRequest) Validate() error {
+	var v model.ValidationErrors
+	if !tenantPattern.MatchString(r.ID) {
+		v = append(v, model.FieldError{Field: "id", Message: "must be 1 to 32 lowercase letters, digits or '-'"})
+	}
+	if r.Name == "" || len(r.Name) > 64 {
+		v = append(v, model.FieldError{Field: "name", Message: "must be 1 to 64 characters"})
+	}
+	if err := r.Admin.Validate(); err != nil {
+		for _, e := range err.(model.ValidationErrors) {
+			if e.Field != "tenant" {
+				v = append(v, model.FieldError{Field: "admin." + e.Field, Message: e.Message})
+			}
+		}
+	}
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// Users can't grant roles above their own, and superadmin exists in the default tenant only
+func (h *Handler) checkGrantedRoles(c echo.Context, roles []string) error {
+	claims := currentClaims(c)
+	for _, role := range roles {
+		if role == model.RoleSuperAdmin && h.db.Tenant() != "" {
+			return model.ValidationErrors{{Field: "roles", Message: "superadmin is only available in the default tenant"}}
+		}
+		if claims == nil || !model.HasRole(claims.Roles, role) {
+			return model.ValidationErrors{{Field: "roles", Message: "can't grant a role above your own: " + role}}
+		}
+	}
+	return nil
+}
+
+// Create a tenant and its first admin (super-admin only)
+func (h *Handler) CreateTenant(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	var body tenantRequest
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(body); err != nil {
+		return invalid(c, err)
+	}
+
+	tenant := model.Tenant{ID: body.ID, Name: body.Name, CreatedAt: time.Now()}
+	if err := h.db.CreateTenant(tenant); err != nil {
+		if err == db.ErrINVALIDDATA {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	admin := model.User{
+		FirstName: body.Admin.FirstName,
+		LastName:  body.Admin.LastName,
+		Email:     body.Admin.Email,
+		Password:  body.Admin.Password,
+		Roles:     []string{model.RoleAdmin},
+	}
+	tenantDB := h.db.ForTenant(tenant.ID)
+	err := tenantDB.CreateIndexes()
+	if err == nil {
+		err = tenantDB.UserSignUp(admin)
+	}
+	if err != nil {
+		// nobody could manage a tenant without its admin
+		if err := h.db.DeleteTenant(tenant.ID); err != nil {
+			log.Printf("tenant %s left without admin: %v", tenant.ID, err)
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditTenantCreate, tenant.ID, nil, map[string]string{"name": tenant.Name, "admin": admin.Email})
+
+	return c.JSON(http.StatusOK, tenant)
+}
+
+// Get every tenant (super-admin only)
+func (h *Handler) GetTenants(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	tenants, err := h.db.GetTenants()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, tenants)
+}
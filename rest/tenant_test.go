@@ -0,0 +1,275 @@
+package rest
+
+import (
+	"app/db"
+	"app/model"
+	"app/service"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Users, sign-in attempts, sessions and audit entries of one tenant, the
+// other DBInterface methods are not used. The tenants created from the
+// default tenant get their own fakeDB.
+type fakeDB struct {
+	db.DBInterface
+	tenant   string
+	users    map[string]model.User
+	attempts map[string]model.LoginAttempt
+	sessions map[string]model.Session
+	used     map[string]bool // MFA tokens
+	revoked  []string        // users whose sessions were revoked
+	keys     []string        // revoked API keys
+	plant    plant
+	pings    int
+	audits   []model.AuditEntry
+	tenants  map[string]*fakeDB
+	fail     map[string]error // error returned by a method, shared with the tenants
+}
+
+func newFakeDB(tenant string) *fakeDB {
+	return &fakeDB{
+		tenant:   tenant,
+		users:    map[string]model.User{},
+		attempts: map[string]model.LoginAttempt{},
+		sessions: map[string]model.Session{},
+		used:     map[string]bool{},
+		tenants:  map[string]*fakeDB{},
+		fail:     map[string]error{},
+	}
+}
+
+func (f *fakeDB) Tenant() string {
+	return f.tenant
+}
+
+func (f *fakeDB) ForTenant(tenant string) db.DBInterface {
+	if t, ok := f.tenants[tenant]; ok {
+		return t
+	}
+	t := newFakeDB(tenant)
+	t.fail = f.fail
+	return t
+}
+
+func (f *fakeDB) CreateTenant(tenant model.Tenant) error {
+	if _, ok := f.tenants[tenant.ID]; ok {
+		return db.ErrINVALIDDATA
+	}
+	t := newFakeDB(tenant.ID)
+	t.fail = f.fail
+	f.tenants[tenant.ID] = t
+	return nil
+}
+
+func (f *fakeDB) DeleteTenant(id string) error {
+	delete(f.tenants, id)
+	return nil
+}
+
+func (f *fakeDB) CreateIndexes() error {
+	return f.fail["CreateIndexes"]
+}
+
+func (f *fakeDB) UserSignUp(user model.User) error {
+	if err := f.fail["UserSignUp"]; err != nil {
+		return err
+	}
+	if _, ok := f.users[user.Email]; ok {
+		return db.ErrINVALIDDATA
+	}
+	f.users[user.Email] = user
+	return nil
+}
+
+func (f *fakeDB) UpdateUserRoles(email string, roles []string) error {
+	user, ok := f.users[email]
+	if !ok {
+		return db.ErrNOTFOUND
+	}
+	user.Roles = roles
+	f.users[email] = user
+	return nil
+}
+
+func (f *fakeDB) UpdateUser(email string, update model.UserUpdate) error {
+	user, ok := f.users[email]
+	if !ok {
+		return db.ErrNOTFOUND
+	}
+	if update.Roles != nil {
+		user.Roles = update.Roles
+	}
+	if update.Sites != nil {
+		user.Sites = update.Sites
+	}
+	f.users[email] = user
+	return nil
+}
+
+func (f *fakeDB) AddAudit(entry model.AuditEntry) error {
+	f.audits = append(f.audits, entry)
+	return nil
+}
+
+func (f *fakeDB) RevokeUserSessions(email string) ([]string, error) {
+	f.revoked = append(f.revoked, email)
+	return nil, nil
+}
+
+func (f *fakeDB) GetAPIKeys(email string) ([]model.APIKey, error) {
+	return nil, nil
+}
+
+func testHandler(store *fakeDB) *Handler {
+	return &Handler{
+		db:            store,
+		conns:         service.NewConnRegistry(),
+		cameraStreams: service.NewCameraStreams(),
+		serverStreams: service.NewServerStreams(),
+		ready:         newReadiness(),
+	}
+}
+
+// Request context of the signed in user, with the :email parameter when given
+func testContext(method, body string, claims *Claims, email string) (echo.Context, *httptest.ResponseRecorder) {
+	e := echo.New()
+	e.Validator = bodyValidator{}
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	if email != "" {
+		c.SetParamNames("email")
+		c.SetParamValues(email)
+	}
+	if claims != nil {
+		c.Set(claimsKey, claims)
+	}
+	return c, rec
+}
+
+func TestGrantedRoles(t *testing.T) {
+	tests := []struct {
+		name   string
+		tenant string
+		caller []string
+		grant  string
+		status int
+	}{
+		{"admin grants operator", "", []string{model.RoleAdmin}, `["operator"]`, http.StatusOK},
+		{"admin grants admin", "", []string{model.RoleAdmin}, `["admin"]`, http.StatusOK},
+		{"admin grants superadmin", "", []string{model.RoleAdmin}, `["superadmin"]`, http.StatusBadRequest},
+		{"admin grants superadmin with viewer", "", []string{model.RoleAdmin}, `["viewer","superadmin"]`, http.StatusBadRequest},
+		{"superadmin grants superadmin", "", []string{model.RoleSuperAdmin}, `["superadmin"]`, http.StatusOK},
+		{"tenant admin grants superadmin", "acme", []string{model.RoleAdmin}, `["superadmin"]`, http.StatusBadRequest},
+		{"operator grants admin", "", []string{model.RoleOperator}, `["admin"]`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		store := newFakeDB(tt.tenant)
+		store.users["erin@example.com"] = model.User{Email: "erin@example.com", Roles: []string{model.RoleViewer}}
+		h := testHandler(store)
+		caller := &Claims{Email: "admin@example.com", Roles: tt.caller, MFA: true, Tenant: tt.tenant}
+
+		for _, call := range []struct {
+			handler func(echo.Context) error
+			body    string
+		}{
+			{h.UpdateUserRoles, `{"roles":` + tt.grant + `}`},
+			{h.UpdateUser, `{"roles":` + tt.grant + `}`},
+		} {
+			c, rec := testContext(http.MethodPut, call.body, caller, "erin@example.com")
+			if err := call.handler(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.status {
+				t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			}
+		}
+		if roles := store.users["erin@example.com"].Roles; tt.status != http.StatusOK && !reflect.DeepEqual(roles, []string{model.RoleViewer}) {
+			t.Errorf("%s: roles changed to %v", tt.name, roles)
+		}
+	}
+}
+
+func TestAdminCantGrantThemselvesSuperAdmin(t *testing.T) {
+	store := newFakeDB("")
+	store.users["admin@example.com"] = model.User{Email: "admin@example.com", Roles: []string{model.RoleAdmin}}
+	h := testHandler(store)
+	caller := &Claims{Email: "admin@example.com", Roles: []string{model.RoleAdmin}, MFA: true}
+
+	c, rec := testContext(http.MethodPut, `{"roles":["superadmin"]}`, caller, "admin@example.com")
+	if err := h.UpdateUserRoles(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if roles := store.users["admin@example.com"].Roles; len(roles) != 1 || roles[0] != model.RoleAdmin {
+		t.Errorf("roles changed to %v", roles)
+	}
+}
+
+func TestTenantIDLength(t *testing.T) {
+	// with the 30 characters of mongo.database, tenant databases stay under 64 bytes
+	if !tenantPattern.MatchString(strings.Repeat("a", 32)) {
+		t.Error("32 characters refused")
+	}
+	if tenantPattern.MatchString(strings.Repeat("a", 33)) {
+		t.Error("33 characters accepted")
+	}
+}
+
+func TestCreateTenant(t *testing.T) {
+	const admin = `"admin":{"firstname":"Erin","lastname":"Doe","email":"erin@acme.com","password":"Correct-Horse-9-Battery"}`
+	tests := []struct {
+		name    string
+		body    string
+		fail    string
+		status  int
+		created bool
+	}{
+		{"created", `{"id":"acme","name":"Acme",` + admin + `}`, "", http.StatusOK, true},
+		{"existing tenant", `{"id":"globex","name":"Globex",` + admin + `}`, "", http.StatusForbidden, false},
+		{"invalid admin", `{"id":"acme","name":"Acme","admin":{"email":"erin"}}`, "", http.StatusBadRequest, false},
+		{"indexes failed", `{"id":"acme","name":"Acme",` + admin + `}`, "CreateIndexes", http.StatusInternalServerError, false},
+		{"admin not created", `{"id":"acme","name":"Acme",` + admin + `}`, "UserSignUp", http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeDB("")
+			if err := store.CreateTenant(model.Tenant{ID: "globex"}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.fail != "" {
+				store.fail[tt.fail] = errors.New("unreachable")
+			}
+			h := testHandler(store)
+			caller := &Claims{Email: "root@example.com", Roles: []string{model.RoleSuperAdmin}, MFA: true}
+
+			c, rec := testContext(http.MethodPost, tt.body, caller, "")
+			if err := h.CreateTenant(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			tenant, created := store.tenants["acme"]
+			if created != tt.created {
+				t.Fatalf("tenant created %v, want %v", created, tt.created)
+			}
+			if created && !reflect.DeepEqual(tenant.users["erin@acme.com"].Roles, []string{model.RoleAdmin}) {
+				t.Errorf("admin roles %v", tenant.users["erin@acme.com"].Roles)
+			}
+			if _, ok := store.tenants["globex"]; !ok {
+				t.Error("existing tenant deleted")
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package rest
+
+import (
+	"app/config"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the certificate files are checked for changes
+const tlsReloadInterval = 10 * time.Second
+
+// Certificate and client CAs read from the configured files, read again
+// when the files change so renewed certificates are served without restart
+type tlsFiles struct {
+	cfg config.TLS
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	stamp     string // sizes and modification times of the files loaded
+}
+
+func newTLSFiles(cfg config.TLS) (*tlsFiles, error) {
+	f := &tlsFiles{cfg: cfg}
+	if err := f.load(f.files()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Sizes and modification times of the files, changes when one is replaced
+func (f *tlsFiles) files() string {
+	stamp := ""
+	for _, path := range []string{f.cfg.Cert, f.cfg.Key, f.cfg.ClientCA} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			stamp += fmt.Sprintf("%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return stamp
+}
+
+func (f *tlsFiles) load(stamp string) error {
+	cert, err := tls.LoadX509KeyPair(f.cfg.Cert, f.cfg.Key)
+	if err != nil {
+		return fmt.Errorf("tls certificate: %v", err)
+	}
+	var pool *x509.CertPool
+	if f.cfg.ClientCA != "" {
+		pem, err := ioutil.ReadFile(f.cfg.ClientCA)
+		if err != nil {
+			return fmt.Errorf("tls client CA: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls client CA: no certificate in %s", f.cfg.ClientCA)
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.cert = &cert
+	f.clientCAs = pool
+	f.stamp = stamp
+	return nil
+}
+
+// Reload the files when they change until the context is done. A file being
+// written may not load, the current certificate is kept until it does.
+func (f *tlsFiles) watch(ctx context.Context) {
+	ticker := time.NewTicker(tlsReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stamp := f.files()
+			f.mutex.RLock()
+			changed := stamp != f.stamp
+			f.mutex.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := f.load(stamp); err != nil {
+				log.Printf("keeping the current certificate: %v", err)
+				continue
+			}
+			log.Print("TLS certificate reloaded")
+		}
+	}
+}
+
+// Server configuration picking the latest certificate and client CAs for each handshake
+func (f *tlsFiles) tlsConfig() *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	if f.cfg.ClientCA != "" {
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+		if f.cfg.ClientAuth == "require" {
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			f.mutex.RLock()
+			defer f.mutex.RUnlock()
+
+			c := base.Clone()
+			c.Certificates = []tls.Certificate{*f.cert}
+			c.ClientCAs = f.clientCAs
+			return c, nil
+		},
+	}
+}
+
+// Redirect plain HTTP requests to the same URL over HTTPS on the TLS address port
+func redirectToHTTPS(tlsAddress string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddress)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,407 @@
+package rest
+
+import (
+	"app/db"
+	"app/model"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrDIRECTORY = errors.New("Accounts are managed by the directory")
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	resetTokenTTL = time.Hour
+)
+
+type pageResponse struct {
+	Items interface{} `json:"items"`
+	Total int64       `json:"total"`
+	Page  int64       `json:"page"`
+	Size  int64       `json:"size"`
+}
+
+// Read the page and size query parameters, 1-based
+func pagination(c echo.Context) (int64, int64, error) {
+	page, size := int64(1), int64(defaultPageSize)
+	if p := c.QueryParam("page"); p != "" {
+		v, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || v < 1 {
+			return 0, 0, fmt.Errorf("invalid page: %s", p)
+		}
+		page = v
+	}
+	if s := c.QueryParam("size"); s != "" {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v < 1 || v > maxPageSize {
+			return 0, 0, fmt.Errorf("invalid size: %s", s)
+		}
+		size = v
+	}
+	return page, size, nil
+}
+
+// Revoke every session of a user and close their streams
+func (h *Handler) revokeUser(email, reason string) error {
+	ids, err := h.db.RevokeUserSessions(email)
+	for _, id := range ids {
+		h.conns.CloseSession(id, reason)
+	}
+	if err != nil {
+		return err
+	}
+	return h.closeAPIKeyStreams(email, reason)
+}
+
+// List users page by page (admin only)
+func (h *Handler) GetUsers(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+
+	page, size, err := pagination(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	users, total, err := h.db.GetUsers(page, size)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, pageResponse{Items: users, Total: total, Page: page, Size: size})
+}
+
+// Get a user (admin only)
+func (h *Handler) GetUser(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("email")
+
+	user, err := h.db.GetUserByEmail(param)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, db.ErrNOTFOUND.Error())
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// Request body of an admin adding a user to their own tenant
+type newUserRequest struct {
+	model.SignUp
+	Roles []string `json:"roles"` // viewer when empty
+	Sites []string `json:"sites"`
+}
+
+func (r newUserRequest) Validate() error {
+	var v model.ValidationErrors
+	if err := r.SignUp.Validate(); err != nil {
+		v = append(v, err.(model.ValidationErrors)...)
+	}
+	if r.Tenant != "" {
+		v = append(v, model.FieldError{Field: "tenant", Message: "users are added to the admin's tenant"})
+	}
+	if len(r.Roles) > 0 || len(r.Sites) > 0 {
+		if err := r.update().Validate(); err != nil {
+			v = append(v, err.(model.ValidationErrors)...)
+		}
+	}
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (r newUserRequest) update() model.UserUpdate {
+	update := model.UserUpdate{Sites: r.Sites}
+	if len(r.Roles) > 0 {
+		update.Roles = r.Roles
+	}
+	return update
+}
+
+// Check the sites exist
+func (h *Handler) checkSites(sites []string) error {
+	for _, site := range sites {
+		if loc, err := h.db.GetLocationByID(site); err != nil || loc.Kind != model.LocationSite {
+			return model.ValidationErrors{{Field: "sites", Message: "unknown site: " + site}}
+		}
+	}
+	return nil
+}
+
+// Add a user to the tenant of the admin, the way into tenants other than the default one (admin only)
+func (h *Handler) CreateUser(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	if !h.authenticator.LocalPasswords() {
+		return c.JSON(http.StatusForbidden, ErrDIRECTORY.Error())
+	}
+
+	var body newUserRequest
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(body); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkGrantedRoles(c, body.Roles); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkSites(body.Sites); err != nil {
+		return invalid(c, err)
+	}
+
+	user := model.User{
+		FirstName: body.FirstName,
+		LastName:  body.LastName,
+		Email:     body.Email,
+		Password:  body.Password,
+		Roles:     body.Roles,
+		Sites:     body.Sites,
+	}
+	if err := h.db.UserSignUp(user); err != nil {
+		if err == db.ErrINVALIDDATA {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditUserCreate, user.Email, nil, map[string]interface{}{
+		"firstname": user.FirstName, "lastname": user.LastName, "roles": body.Roles, "sites": body.Sites,
+	})
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Edit the names, password or roles of a user (admin only)
+func (h *Handler) UpdateUser(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("email")
+
+	var update model.UserUpdate
+	if err := c.Bind(&update); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(update); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkGrantedRoles(c, update.Roles); err != nil {
+		return invalid(c, err)
+	}
+	if err := h.checkSites(update.Sites); err != nil {
+		return invalid(c, err)
+	}
+
+	if err := h.db.UpdateUser(param, update); err != nil {
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	// tokens carry the roles and open streams were scoped with the old sites,
+	// the user signs in again to get the new ones
+	if update.Roles != nil || update.Sites != nil {
+		if err := h.revokeUser(param, "roles or sites changed"); err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	h.audit(c, auditUserUpdate, param, nil, redactedUserUpdate(update))
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Disable a user and end their sessions (admin only)
+func (h *Handler) DisableUser(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("email")
+
+	if err := h.db.SetUserDisabled(param, true); err != nil {
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if err := h.revokeUser(param, "account disabled"); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditUserDisable, param, nil, nil)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Enable a disabled user again (admin only)
+func (h *Handler) EnableUser(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("email")
+
+	if err := h.db.SetUserDisabled(param, false); err != nil {
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditUserEnable, param, nil, nil)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Delete a user and end their sessions (admin only)
+func (h *Handler) DeleteUser(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	param := c.Param("email")
+
+	before, _ := h.db.GetUserByEmail(param)
+	if err := h.db.DeleteUser(param); err != nil {
+		if err == db.ErrNOTFOUND {
+			return c.JSON(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if err := h.revokeUser(param, "account deleted"); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.audit(c, auditUserDelete, param, before, nil)
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Change the password of the signed in user
+func (h *Handler) ChangePassword(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	if !h.authenticator.LocalPasswords() {
+		return c.JSON(http.StatusForbidden, ErrDIRECTORY.Error())
+	}
+
+	var body changePasswordRequest
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(body); err != nil {
+		return invalid(c, err)
+	}
+
+	if err := h.db.ChangePassword(currentClaims(c).Email, body.OldPassword, body.NewPassword); err != nil {
+		if err == db.ErrINVALIDPASSWORD {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Send a password reset token to the user.
+// Always succeeds so it can't be used to find out which accounts exist.
+func (h *Handler) ForgotPassword(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	var body struct {
+		Tenant string `json:"tenant"`
+		Email  string `json:"email"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if !h.tenantExists(body.Tenant) {
+		return c.JSON(http.StatusOK, "Success")
+	}
+	h = h.forTenant(body.Tenant)
+
+	if !h.authenticator.LocalPasswords() {
+		return c.JSON(http.StatusForbidden, ErrDIRECTORY.Error())
+	}
+
+	user, err := h.db.GetUserByEmail(body.Email)
+	if err != nil || user.Disabled {
+		return c.JSON(http.StatusOK, "Success")
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	expires := time.Now().Add(resetTokenTTL)
+	reset := model.PasswordReset{Token: hashToken(token), Email: user.Email, ExpiresAt: expires}
+	if err := h.db.CreatePasswordReset(reset); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	msg := fmt.Sprintf("Use this token to reset your password before %s:\n\n%s", expires.Format(time.RFC1123), token)
+	if err := h.notifier.Notify(user.Email, "Password reset", msg); err != nil {
+		log.Print(err)
+		return c.JSON(http.StatusInternalServerError, "could not send the reset token")
+	}
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// Set a new password with a reset token and end the user's sessions
+func (h *Handler) ResetPassword(c echo.Context) error {
+	if h.db == nil {
+		return c.JSON(http.StatusInternalServerError, "server database error")
+	}
+	var body resetPasswordRequest
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(body); err != nil {
+		return invalid(c, err)
+	}
+
+	reset, err := h.db.ConsumePasswordReset(hashToken(body.Token))
+	if err != nil {
+		return c.JSON(http.StatusForbidden, "invalid or expired token")
+	}
+	h = h.forTenant(reset.Tenant)
+
+	if !h.authenticator.LocalPasswords() {
+		return c.JSON(http.StatusForbidden, ErrDIRECTORY.Error())
+	}
+
+	if err := h.db.UpdateUser(reset.Email, model.UserUpdate{Password: &body.Password}); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if err := h.revokeUser(reset.Email, "password reset"); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, "Success")
+}
+
+// User update for the audit log, telling whether the password changed without the password
+func redactedUserUpdate(update model.UserUpdate) interface{} {
+	changed := update.Password != nil
+	update.Password = nil
+	return struct {
+		model.UserUpdate
+		PasswordChanged bool `json:"password_changed,omitempty"`
+	}{update, changed}
+}
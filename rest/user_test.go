@@ -0,0 +1,94 @@
+package rest
+
+import (
+	"app/auth"
+	"app/model"
+	"net/http"
+	"testing"
+)
+
+func TestRedactedUserUpdate(t *testing.T) {
+	password := "new1password"
+	name := "Erin"
+	payload := auditPayload(redactedUserUpdate(model.UserUpdate{FirstName: &name, Password: &password}))
+
+	if payload["password"] != nil {
+		t.Errorf("password audited: %v", payload["password"])
+	}
+	if payload["password_changed"] != true {
+		t.Errorf("password_changed = %v, want true", payload["password_changed"])
+	}
+	if payload["firstname"] != name {
+		t.Errorf("firstname = %v, want %s", payload["firstname"], name)
+	}
+	if _, ok := auditPayload(redactedUserUpdate(model.UserUpdate{FirstName: &name}))["password_changed"]; ok {
+		t.Error("password_changed audited without a password change")
+	}
+}
+
+func TestSignUpAsksForNoRole(t *testing.T) {
+	store := newFakeDB("")
+	h := testHandler(store)
+	h.authenticator = &auth.Local{DB: store}
+
+	// even the first user of a fresh deployment, the first admin comes from create-admin
+	c, rec := testContext(http.MethodPost, `{"firstname":"Erin","lastname":"Eve","email":"erin@example.com","password":"erin1password","roles":["superadmin"]}`, nil, "")
+	if err := h.SignUp(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if roles := store.users["erin@example.com"].Roles; len(roles) != 0 {
+		t.Errorf("signed up with roles %v, want none (viewer)", roles)
+	}
+}
+
+func TestRoleChangeRevokesSessions(t *testing.T) {
+	store := newFakeDB("")
+	store.users["erin@example.com"] = model.User{Email: "erin@example.com", Roles: []string{model.RoleAdmin}}
+	h := testHandler(store)
+	admin := &Claims{Email: "admin@example.com", Roles: []string{model.RoleAdmin}, MFA: true}
+
+	c, rec := testContext(http.MethodPut, `{"roles":["viewer"]}`, admin, "erin@example.com")
+	if err := h.UpdateUserRoles(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if len(store.revoked) != 1 || store.revoked[0] != "erin@example.com" {
+		t.Errorf("revoked sessions of %v, want erin@example.com", store.revoked)
+	}
+}
+
+func TestUserUpdateRevokesSessions(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		revoke bool
+	}{
+		{"names", `{"firstname":"Erin"}`, false},
+		{"roles", `{"roles":["viewer"]}`, true},
+		{"sites lifted", `{"sites":[]}`, true},
+	}
+	admin := &Claims{Email: "admin@example.com", Roles: []string{model.RoleAdmin}, MFA: true}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeDB("")
+			store.users["erin@example.com"] = model.User{Email: "erin@example.com", Roles: []string{model.RoleOperator}}
+			h := testHandler(store)
+
+			c, rec := testContext(http.MethodPut, tt.body, admin, "erin@example.com")
+			if err := h.UpdateUser(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if revoked := len(store.revoked) > 0; revoked != tt.revoke {
+				t.Errorf("revoked %v, want %v", revoked, tt.revoke)
+			}
+		})
+	}
+}
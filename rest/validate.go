@@ -0,0 +1,55 @@
+package rest
+
+import (
+	"app/model"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// echo.Validator for request bodies having a Validate method
+type bodyValidator struct{}
+
+func (bodyValidator) Validate(i interface{}) error {
+	if v, ok := i.(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+type validationResponse struct {
+	Message string                 `json:"message"`
+	Errors  model.ValidationErrors `json:"errors"`
+}
+
+// Respond 400 with the invalid fields
+func invalid(c echo.Context, err error) error {
+	if v, ok := err.(model.ValidationErrors); ok {
+		return c.JSON(http.StatusBadRequest, validationResponse{Message: "Invalid data", Errors: v})
+	}
+	return c.JSON(http.StatusBadRequest, err.Error())
+}
+
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+func (r changePasswordRequest) Validate() error {
+	if msg := model.PasswordProblem(r.NewPassword); msg != "" {
+		return model.ValidationErrors{{Field: "new_password", Message: msg}}
+	}
+	return nil
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (r resetPasswordRequest) Validate() error {
+	if msg := model.PasswordProblem(r.Password); msg != "" {
+		return model.ValidationErrors{{Field: "password", Message: msg}}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Live streams, for the health endpoints
+type SessionStats struct {
+	WebRTC int `json:"webrtc"`
+	OpcUA  int `json:"opcua"`
+}
+
+// Connection state of an OPC UA server streamed to clients
+type ServerStatus struct {
+	Endpoint  string     `json:"endpoint"`
+	Sessions  int        `json:"sessions"`  // streams of the server
+	Connected int        `json:"connected"` // streams connected to it
+	LastError string     `json:"lasterror,omitempty"`
+	ErrorAt   *time.Time `json:"errorat,omitempty"`
+}
+
+type sessionTracker struct {
+	mutex   sync.Mutex
+	webrtc  int
+	opcua   int
+	servers map[string]*ServerStatus // by endpoint
+}
+
+var sessions = &sessionTracker{servers: map[string]*ServerStatus{}}
+
+func ActiveSessions() SessionStats {
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	return SessionStats{WebRTC: sessions.webrtc, OpcUA: sessions.opcua}
+}
+
+// State of the servers being streamed, and of those that failed since they last were
+func ServerStatuses() []ServerStatus {
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	list := make([]ServerStatus, 0, len(sessions.servers))
+	for _, s := range sessions.servers {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Endpoint < list[j].Endpoint })
+	return list
+}
+
+// Path of the ffmpeg executable, an error when it can't be found
+func FFmpegPath() (string, error) {
+	return exec.LookPath(FFmpeg.Path)
+}
+
+func (s *sessionTracker) add(count *int, n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	*count += n
+}
+
+func (s *sessionTracker) server(endpoint string) *ServerStatus {
+	if s.servers[endpoint] == nil {
+		s.servers[endpoint] = &ServerStatus{Endpoint: endpoint}
+	}
+	return s.servers[endpoint]
+}
+
+func (s *sessionTracker) serverStarted(endpoint string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.server(endpoint).Sessions++
+}
+
+// A stream of the server ended, forget the server once it has no stream and no error to report
+func (s *sessionTracker) serverEnded(endpoint string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.server(endpoint)
+	status.Sessions--
+	if status.Sessions == 0 && status.LastError == "" {
+		delete(s.servers, endpoint)
+	}
+}
+
+func (s *sessionTracker) serverConnected(endpoint string, n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.server(endpoint)
+	status.Connected += n
+	if n > 0 {
+		status.LastError = ""
+		status.ErrorAt = nil
+	}
+}
+
+func (s *sessionTracker) serverFailed(endpoint string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.server(endpoint)
+	status.LastError = err.Error()
+	now := time.Now()
+	status.ErrorAt = &now
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Delivers messages to users, e.g. password reset tokens
+type Notifier interface {
+	Notify(to, subject, body string) error
+}
+
+// Writes messages to the server log
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(to, subject, body string) error {
+	log.Printf("notify: to=%s subject=%q body=%q", to, subject, body)
+	return nil
+}
+
+// Appends messages to a file, for installs without a mail server
+type FileNotifier struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+func (f *FileNotifier) Notify(to, subject, body string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC3339), to, subject, body)
+	return err
+}
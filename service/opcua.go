@@ -1,16 +1,17 @@
 package service
 
 import (
+	"app/metrics"
 	"app/model"
 	"context"
+	"fmt"
 	"log"
-	"os"
-	"os/signal"
 	"time"
 
 	"github.com/gopcua/opcua"
 	"github.com/gopcua/opcua/monitor"
 	"github.com/gopcua/opcua/ua"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
@@ -28,8 +29,13 @@ type opcData struct {
 	Value  interface{} `json:"value"`
 }
 
-// Set opc ua server options
-func (t *ThreadSafeWriter) SetConfigurationAndRun(config model.OpcUAServer) {
+// Set opc ua server options and stream the server nodes to the websocket client.
+// The first configuration received from configs starts the stream, later ones
+// update the monitored nodes or reconnect when the connection settings changed.
+// Start it with Go so that Shutdown waits for it.
+func (t *ThreadSafeWriter) SetConfigurationAndRun(configs <-chan model.OpcUAServer) {
+	defer t.Conn.Close()
+
 	interval := opcua.DefaultSubscriptionInterval.String() //100ms
 
 	subInterval, err := time.ParseDuration(interval)
@@ -37,26 +43,45 @@ func (t *ThreadSafeWriter) SetConfigurationAndRun(config model.OpcUAServer) {
 		return
 	}
 
-	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, os.Interrupt)
+	sessions.add(&sessions.opcua, 1)
+	defer sessions.add(&sessions.opcua, -1)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// stops the stream on shutdown
+	ctx, cancel := context.WithCancel(shutdownCtx)
 	defer cancel()
 
-	go func() {
-		<-signalCh
-		println()
-		cancel()
-	}()
+	var config model.OpcUAServer
+	select {
+	case config = <-configs:
+	case <-ctx.Done():
+		return
+	}
+	for {
+		next := t.runServer(ctx, subInterval, config, configs)
+		if next == nil {
+			return
+		}
+		log.Printf("Reconnect opc ua server: %s", next.Endpoint)
+		config = *next
+	}
+}
+
+// Connect to the server and stream its nodes until the context is done, the
+// client goes away or the connection settings change, which are returned.
+func (t *ThreadSafeWriter) runServer(ctx context.Context, subInterval time.Duration, config model.OpcUAServer, configs <-chan model.OpcUAServer) *model.OpcUAServer {
+	sessions.serverStarted(config.Endpoint)
+	defer sessions.serverEnded(config.Endpoint)
 
 	endpoints, err := opcua.GetEndpoints(config.Endpoint)
 	if err != nil {
-		return
+		sessions.serverFailed(config.Endpoint, err)
+		return nil
 	}
 
 	ep := opcua.SelectEndpoint(endpoints, config.Policy, ua.MessageSecurityModeFromString(config.Mode))
 	if ep == nil {
-		return
+		sessions.serverFailed(config.Endpoint, fmt.Errorf("no endpoint with policy %q and mode %q", config.Policy, config.Mode))
+		return nil
 	}
 
 	opts := []opcua.Option{
@@ -70,42 +95,102 @@ func (t *ThreadSafeWriter) SetConfigurationAndRun(config model.OpcUAServer) {
 
 	c := opcua.NewClient(config.Endpoint, opts...)
 	if err := c.Connect(ctx); err != nil {
-		return
+		sessions.serverFailed(config.Endpoint, err)
+		return nil
 	}
 
 	defer c.Close()
+	sessions.serverConnected(config.Endpoint, 1)
+	defer sessions.serverConnected(config.Endpoint, -1)
 
 	m, err := monitor.NewNodeMonitor(c)
 	if err != nil {
-		return
+		sessions.serverFailed(config.Endpoint, err)
+		return nil
 	}
 
 	m.SetErrorHandler(func(_ *opcua.Client, sub *monitor.Subscription, err error) {
+		sessions.serverFailed(config.Endpoint, err)
 		log.Printf("error: sub=%d err=%s", sub.SubscriptionID(), err.Error())
 	})
 
-	// start channel-based subscription
-	go t.RunChannelBasedSub(ctx, m, subInterval, 0, config.NodeID...)
+	// start channel-based subscription, stop when it can't write to the client anymore
+	nodes := make(chan []string, 1)
+	subDone := make(chan struct{})
+	subCtx, subCancel := context.WithCancel(ctx)
+	defer func() {
+		// unsubscribe before closing the client
+		subCancel()
+		<-subDone
+	}()
+
+	go func() {
+		defer close(subDone)
+		t.RunChannelBasedSub(subCtx, m, config.Name, subInterval, 0, nodes, config.NodeID...)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-subDone:
+			return nil
 
-	<-ctx.Done()
+		case next := <-configs:
+			if !sameConnection(config, next) {
+				return &next
+			}
+			// keep only the latest node list if the subscription didn't pick the previous one yet
+			select {
+			case <-nodes:
+			default:
+			}
+			nodes <- next.NodeID
+			config = next
+		}
+	}
 }
 
-func (t *ThreadSafeWriter) RunChannelBasedSub(ctx context.Context, m *monitor.NodeMonitor, interval, lag time.Duration, nodes ...string) {
+// Whether two configurations connect the same way, so only the nodes may differ
+func sameConnection(a, b model.OpcUAServer) bool {
+	return a.Endpoint == b.Endpoint && a.Policy == b.Policy && a.Mode == b.Mode && a.Cert == b.Cert && a.Key == b.Key
+}
+
+// Write the data changes of the nodes to the websocket client. Node lists
+// received from updates replace the monitored nodes.
+func (t *ThreadSafeWriter) RunChannelBasedSub(ctx context.Context, m *monitor.NodeMonitor, server string, interval, lag time.Duration, updates <-chan []string, nodes ...string) {
 	ch := make(chan *monitor.DataChangeMessage, 16)
 	sub, err := m.ChanSubscribe(ctx, &opcua.SubscriptionParameters{Interval: interval}, ch, nodes...)
 	if err != nil {
 		return
 	}
 
+	stats := newSubStats(sub, t.Tenant, server)
 	defer func() {
-		Cleanup(sub)
+		Cleanup(sub, stats)
 	}()
 
+	current := nodes
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
+		case next := <-updates:
+			added, removed := diffNodes(current, next)
+			if len(removed) > 0 {
+				if err := sub.RemoveNodes(removed...); err != nil {
+					log.Printf("[channel ] sub=%d error=%s", sub.SubscriptionID(), err)
+				}
+			}
+			if len(added) > 0 {
+				if err := sub.AddNodes(added...); err != nil {
+					log.Printf("[channel ] sub=%d error=%s", sub.SubscriptionID(), err)
+				}
+			}
+			current = next
+
 		case msg := <-ch:
 			if msg.Error != nil {
 				log.Printf("[channel ] sub=%d error=%s", sub.SubscriptionID(), msg.Error)
@@ -123,13 +208,62 @@ func (t *ThreadSafeWriter) RunChannelBasedSub(ctx context.Context, m *monitor.No
 				if err := t.Conn.WriteJSON(opcMessage{Event: "event", Data: opcData}); err != nil {
 					return
 				}
+				stats.export()
 			}
 			time.Sleep(lag)
 		}
 	}
 }
 
-func Cleanup(sub *monitor.Subscription) {
+// Nodes only in next, and nodes only in current
+func diffNodes(current, next []string) ([]string, []string) {
+	inCurrent := map[string]bool{}
+	for _, n := range current {
+		inCurrent[n] = true
+	}
+	inNext := map[string]bool{}
+	var added, removed []string
+	for _, n := range next {
+		inNext[n] = true
+		if !inCurrent[n] {
+			added = append(added, n)
+		}
+	}
+	for _, n := range current {
+		if !inNext[n] {
+			removed = append(removed, n)
+		}
+	}
+	return added, removed
+}
+
+func Cleanup(sub *monitor.Subscription, stats *subStats) {
 	log.Printf("stats: sub=%d delivered=%d dropped=%d", sub.SubscriptionID(), sub.Delivered(), sub.Dropped())
+	stats.export()
 	sub.Unsubscribe()
 }
+
+// Delivered and dropped counts of a subscription, added to the counters of its
+// server as they grow. Subscriptions come and go, so they aren't a label.
+type subStats struct {
+	sub                *monitor.Subscription
+	delivered, dropped prometheus.Counter
+	lastDelivered      uint64
+	lastDropped        uint64
+}
+
+func newSubStats(sub *monitor.Subscription, tenant, server string) *subStats {
+	return &subStats{
+		sub:       sub,
+		delivered: metrics.OpcUADelivered.WithLabelValues(tenant, server),
+		dropped:   metrics.OpcUADropped.WithLabelValues(tenant, server),
+	}
+}
+
+// Add the counts since the last export
+func (s *subStats) export() {
+	delivered, dropped := s.sub.Delivered(), s.sub.Dropped()
+	s.delivered.Add(float64(delivered - s.lastDelivered))
+	s.dropped.Add(float64(dropped - s.lastDropped))
+	s.lastDelivered, s.lastDropped = delivered, dropped
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"app/model"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Open websocket streams grouped by the sign-in session that opened them,
+// so they can be closed when the session is revoked.
+type ConnRegistry struct {
+	mutex sync.Mutex
+	conns map[string]map[*ThreadSafeWriter]struct{}
+}
+
+func NewConnRegistry() *ConnRegistry {
+	return &ConnRegistry{conns: map[string]map[*ThreadSafeWriter]struct{}{}}
+}
+
+func (r *ConnRegistry) Add(session string, t *ThreadSafeWriter) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.conns[session] == nil {
+		r.conns[session] = map[*ThreadSafeWriter]struct{}{}
+	}
+	r.conns[session][t] = struct{}{}
+}
+
+func (r *ConnRegistry) Remove(session string, t *ThreadSafeWriter) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.conns[session], t)
+	if len(r.conns[session]) == 0 {
+		delete(r.conns, session)
+	}
+}
+
+// Close every stream of the session with a close frame carrying the reason
+func (r *ConnRegistry) CloseSession(session, reason string) {
+	r.mutex.Lock()
+	conns := r.conns[session]
+	delete(r.conns, session)
+	r.mutex.Unlock()
+
+	for t := range conns {
+		t.Close(websocket.ClosePolicyViolation, reason)
+	}
+}
+
+// Close the websocket streams of every session with a close frame carrying the reason
+func (r *ConnRegistry) CloseAll(code int, reason string) {
+	r.mutex.Lock()
+	conns := r.conns
+	r.conns = map[string]map[*ThreadSafeWriter]struct{}{}
+	r.mutex.Unlock()
+
+	for _, session := range conns {
+		for t := range session {
+			t.Close(code, reason)
+		}
+	}
+}
+
+// Send a close frame and close the underlying connection
+func (t *ThreadSafeWriter) Close(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := t.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		log.Print(err)
+	}
+	t.Conn.Close()
+}
+
+// Sources of the live streams of each camera, by camera key, so the streams
+// follow changes to the camera.
+type CameraStreams struct {
+	mutex   sync.Mutex
+	streams map[string]map[chan string]struct{}
+}
+
+func NewCameraStreams() *CameraStreams {
+	return &CameraStreams{streams: map[string]map[chan string]struct{}{}}
+}
+
+// Register a stream of the camera, its source starts with the camera URL
+func (s *CameraStreams) Add(cam string, rtsp string) chan string {
+	source := make(chan string, 1)
+	source <- rtsp
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.streams[cam] == nil {
+		s.streams[cam] = map[chan string]struct{}{}
+	}
+	s.streams[cam][source] = struct{}{}
+	return source
+}
+
+// Unregister a stream, wherever the camera was renamed to meanwhile
+func (s *CameraStreams) Remove(source chan string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for cam, streams := range s.streams {
+		if _, ok := streams[source]; ok {
+			delete(streams, source)
+			if len(streams) == 0 {
+				delete(s.streams, cam)
+			}
+			return
+		}
+	}
+}
+
+// Move the streams of a camera to its new name and restart them on the new
+// URL, unless it is empty.
+func (s *CameraStreams) Update(cam, newName, rtsp string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	streams := s.streams[cam]
+	delete(s.streams, cam)
+	for source := range streams {
+		if rtsp == "" {
+			break
+		}
+		// keep only the latest URL if the stream didn't pick the previous one yet
+		select {
+		case <-source:
+		default:
+		}
+		source <- rtsp
+	}
+	if len(streams) > 0 {
+		if s.streams[newName] == nil {
+			s.streams[newName] = map[chan string]struct{}{}
+		}
+		for source := range streams {
+			s.streams[newName][source] = struct{}{}
+		}
+	}
+}
+
+// Configuration sources of the live OPC UA streams of each server, by server
+// key, so the streams follow changes to the server definition. Streams of a
+// machine only get the machine's nodes, and follow changes to the machine.
+type ServerStreams struct {
+	mutex   sync.Mutex
+	streams map[string]map[chan model.OpcUAServer]serverStream
+}
+
+type serverStream struct {
+	machine string   // key of the streamed machine, if any
+	nodes   []string // node filter, nil for every node
+}
+
+func NewServerStreams() *ServerStreams {
+	return &ServerStreams{streams: map[string]map[chan model.OpcUAServer]serverStream{}}
+}
+
+// Register a stream of the server, its source starts with the server definition
+func (s *ServerStreams) Add(key string, server model.OpcUAServer) chan model.OpcUAServer {
+	return s.AddFiltered(key, server, nil)
+}
+
+// Register a stream of some nodes of the server, nil for every node. Its
+// source starts with the server definition keeping only these nodes.
+func (s *ServerStreams) AddFiltered(key string, server model.OpcUAServer, nodes []string) chan model.OpcUAServer {
+	return s.add(key, server, serverStream{nodes: nodes})
+}
+
+// Register a stream of the nodes of a machine, moved by UpdateMachine when
+// the machine changes
+func (s *ServerStreams) AddMachine(key, machine string, server model.OpcUAServer, nodes []string) chan model.OpcUAServer {
+	return s.add(key, server, serverStream{machine: machine, nodes: nodes})
+}
+
+func (s *ServerStreams) add(key string, server model.OpcUAServer, stream serverStream) chan model.OpcUAServer {
+	source := make(chan model.OpcUAServer, 1)
+	source <- FilterNodes(server, stream.nodes)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.streams[key] == nil {
+		s.streams[key] = map[chan model.OpcUAServer]serverStream{}
+	}
+	s.streams[key][source] = stream
+	return source
+}
+
+// Unregister a stream, wherever the server was renamed to meanwhile
+func (s *ServerStreams) Remove(source chan model.OpcUAServer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for name, streams := range s.streams {
+		if _, ok := streams[source]; ok {
+			delete(streams, source)
+			if len(streams) == 0 {
+				delete(s.streams, name)
+			}
+			return
+		}
+	}
+}
+
+// Send the new definition of a server to its streams, moving them to its new key
+func (s *ServerStreams) Update(key, newKey string, server model.OpcUAServer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	streams := s.streams[key]
+	delete(s.streams, key)
+	for source, stream := range streams {
+		sendLatest(source, FilterNodes(server, stream.nodes))
+	}
+	if len(streams) > 0 {
+		if s.streams[newKey] == nil {
+			s.streams[newKey] = map[chan model.OpcUAServer]serverStream{}
+		}
+		for source, stream := range streams {
+			s.streams[newKey][source] = stream
+		}
+	}
+}
+
+// Move the streams of a machine to the server of the given key, now with
+// only the given nodes, and to the machine's new key
+func (s *ServerStreams) UpdateMachine(machine, newMachine, key string, server model.OpcUAServer, nodes []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	moved := map[chan model.OpcUAServer]serverStream{}
+	for name, streams := range s.streams {
+		for source, stream := range streams {
+			if stream.machine != machine {
+				continue
+			}
+			delete(streams, source)
+			moved[source] = serverStream{machine: newMachine, nodes: nodes}
+		}
+		if len(streams) == 0 {
+			delete(s.streams, name)
+		}
+	}
+	for source, stream := range moved {
+		sendLatest(source, FilterNodes(server, nodes))
+		if s.streams[key] == nil {
+			s.streams[key] = map[chan model.OpcUAServer]serverStream{}
+		}
+		s.streams[key][source] = stream
+	}
+}
+
+// Send a definition to a stream, keeping only the latest one if the stream
+// didn't pick the previous one yet
+func sendLatest(source chan model.OpcUAServer, server model.OpcUAServer) {
+	select {
+	case <-source:
+	default:
+	}
+	source <- server
+}
+
+// The server with only the nodes also in the filter, all of them for a nil filter
+func FilterNodes(server model.OpcUAServer, nodes []string) model.OpcUAServer {
+	if nodes == nil {
+		return server
+	}
+	kept := map[string]bool{}
+	for _, id := range nodes {
+		kept[id] = true
+	}
+	filtered := []string{}
+	for _, id := range server.NodeID {
+		if kept[id] {
+			filtered = append(filtered, id)
+		}
+	}
+	server.NodeID = filtered
+	return server
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"app/model"
+	"reflect"
+	"testing"
+)
+
+func TestServerStreamsUpdateFiltersMachineNodes(t *testing.T) {
+	streams := NewServerStreams()
+	server := model.OpcUAServer{Name: "plc", Endpoint: "opc.tcp://plc:4840", NodeID: []string{"ns=2;s=A", "ns=2;s=B"}}
+
+	all := streams.Add("/plc", server)
+	machine := streams.AddFiltered("/plc", server, []string{"ns=2;s=B", "ns=2;s=C"})
+	if got := (<-all).NodeID; !reflect.DeepEqual(got, server.NodeID) {
+		t.Errorf("server stream starts with %v", got)
+	}
+	if got := (<-machine).NodeID; !reflect.DeepEqual(got, []string{"ns=2;s=B"}) {
+		t.Errorf("machine stream starts with %v", got)
+	}
+
+	server.Name = "press"
+	server.NodeID = []string{"ns=2;s=A", "ns=2;s=B", "ns=2;s=C"}
+	streams.Update("/plc", "/press", server)
+	if got := (<-all).NodeID; !reflect.DeepEqual(got, server.NodeID) {
+		t.Errorf("server stream updated to %v", got)
+	}
+	if got := (<-machine).NodeID; !reflect.DeepEqual(got, []string{"ns=2;s=B", "ns=2;s=C"}) {
+		t.Errorf("machine stream updated to %v", got)
+	}
+
+	// the streams moved to the new key
+	streams.Update("/press", "/press", server)
+	select {
+	case <-machine:
+	default:
+		t.Error("machine stream not updated under the new key")
+	}
+}
+
+func TestServerStreamsUpdateMachine(t *testing.T) {
+	streams := NewServerStreams()
+	plc := model.OpcUAServer{Name: "plc", Endpoint: "opc.tcp://plc:4840", NodeID: []string{"ns=2;s=A", "ns=2;s=B"}}
+	hmi := model.OpcUAServer{Name: "hmi", Endpoint: "opc.tcp://hmi:4840", NodeID: []string{"ns=3;s=X"}}
+
+	all := streams.Add("/plc", plc)
+	machine := streams.AddMachine("/plc", "/press", plc, []string{"ns=2;s=A"})
+	<-all
+	<-machine
+
+	// other nodes of the same server
+	streams.UpdateMachine("/press", "/press", "/plc", plc, []string{"ns=2;s=B"})
+	if got := (<-machine).NodeID; !reflect.DeepEqual(got, []string{"ns=2;s=B"}) {
+		t.Errorf("machine stream updated to %v", got)
+	}
+	select {
+	case got := <-all:
+		t.Errorf("server stream updated to %v", got.NodeID)
+	default:
+	}
+
+	// renamed and moved to another server
+	streams.UpdateMachine("/press", "/stamp", "/hmi", hmi, []string{"ns=3;s=X"})
+	if got := <-machine; got.Endpoint != hmi.Endpoint || !reflect.DeepEqual(got.NodeID, []string{"ns=3;s=X"}) {
+		t.Errorf("machine stream moved to %s %v", got.Endpoint, got.NodeID)
+	}
+	streams.Update("/plc", "/plc", plc)
+	<-all
+	select {
+	case <-machine:
+		t.Error("machine stream still follows its previous server")
+	default:
+	}
+	streams.Update("/hmi", "/hmi", hmi)
+	select {
+	case <-machine:
+	default:
+		t.Error("machine stream doesn't follow its new server")
+	}
+	streams.UpdateMachine("/stamp", "/stamp", "/hmi", hmi, []string{})
+	if got := (<-machine).NodeID; len(got) != 0 {
+		t.Errorf("machine stream without nodes streams %v", got)
+	}
+}
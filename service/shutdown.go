@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	// done on shutdown, ends every stream
+	shutdownCtx, shutdown = context.WithCancel(context.Background())
+	// streams, ffmpeg processes and OPC UA connections still running
+	running sync.WaitGroup
+)
+
+// Run f in a goroutine Shutdown waits for. It is counted before the goroutine
+// starts, so a stream a handler starts can't be missed by a later Shutdown.
+func Go(f func()) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		f()
+	}()
+}
+
+// End the streams: close the peer connections, kill ffmpeg and unsubscribe
+// the OPC UA monitors, waiting for them until the context is done.
+func Shutdown(ctx context.Context) error {
+	shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
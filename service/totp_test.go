@@ -0,0 +1,69 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA-1 with the ASCII key "12345678901234567890".
+// The 6-digit codes are the last digits of the 8-digit ones.
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+var rfc6238Vectors = []struct {
+	time int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestValidateTOTPVectors(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		at := time.Unix(v.time, 0)
+		step, ok := MatchTOTP(rfc6238Secret, v.code, at)
+		if !ok {
+			t.Errorf("%d: %s refused", v.time, v.code)
+			continue
+		}
+		if want := v.time / 30; step != want {
+			t.Errorf("%d: step %d, want %d", v.time, step, want)
+		}
+		if !ValidateTOTP(rfc6238Secret, v.code, at) {
+			t.Errorf("%d: ValidateTOTP refused %s", v.time, v.code)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	at := time.Unix(1234567890, 0) // step 41152263
+	tests := []struct {
+		offset time.Duration
+		ok     bool
+	}{
+		{-totpPeriod, true},
+		{totpPeriod, true},
+		{-2 * totpPeriod, false},
+		{2 * totpPeriod, false},
+	}
+	for _, tt := range tests {
+		if got := ValidateTOTP(rfc6238Secret, "005924", at.Add(tt.offset)); got != tt.ok {
+			t.Errorf("%s away: %v, want %v", tt.offset, got, tt.ok)
+		}
+	}
+}
+
+func TestValidateTOTPRefusesMalformed(t *testing.T) {
+	at := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082", "abcdef"} {
+		if ValidateTOTP(rfc6238Secret, code, at) {
+			t.Errorf("%q accepted", code)
+		}
+	}
+	if ValidateTOTP("not base32!", "287082", at) {
+		t.Error("invalid secret accepted")
+	}
+}
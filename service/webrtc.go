@@ -1,6 +1,8 @@
 package service
 
 import (
+	"app/config"
+	"app/metrics"
 	"context"
 	"encoding/json"
 	"io"
@@ -13,6 +15,7 @@ import (
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
 	"github.com/pion/webrtc/v3/pkg/media/h264reader"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type message struct {
@@ -22,7 +25,8 @@ type message struct {
 
 // Helper to make Gorilla Websockets threadsafe
 type ThreadSafeWriter struct {
-	Conn *websocket.Conn
+	Conn   *websocket.Conn
+	Tenant string // labels the stream metrics
 }
 
 func (t *ThreadSafeWriter) WriteJSON(v interface{}) error {
@@ -37,16 +41,30 @@ var (
 	peerConnection = &webrtc.PeerConnection{}
 	test_rtsp_url  = "rtsp://wowzaec2demo.streamlock.net/vod/mp4:BigBuckBunny_115k.mov"
 	test_video     = "output.h264"
+
+	// ffmpeg command transcoding the camera streams, set from the configuration
+	FFmpeg = config.Default().FFmpeg
 )
 
-func (t *ThreadSafeWriter) WebRTCStreamH264(rtsp_url string) error {
+// Stream the camera to the websocket client over WebRTC. The first RTSP URL
+// received from source starts the stream, later ones restart it on the new URL.
+// Start it with Go so that Shutdown waits for it.
+func (t *ThreadSafeWriter) WebRTCStreamH264(camera string, source <-chan string) error {
 	defer t.Conn.Close()
 
+	// stops ffmpeg when the client goes away or on shutdown
+	streamCtx, streamCtxCancel := context.WithCancel(shutdownCtx)
+	defer streamCtxCancel()
+
 	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
 	if err != nil {
 		return err
 	}
 	defer peerConnection.Close()
+	metrics.PeerConnections.Inc()
+	defer metrics.PeerConnections.Dec()
+	sessions.add(&sessions.webrtc, 1)
+	defer sessions.add(&sessions.webrtc, -1)
 
 	// Add track and streaming h264 codec rtsp video using ffmepg
 	// Create a video track and rtpSender
@@ -101,47 +119,37 @@ func (t *ThreadSafeWriter) WebRTCStreamH264(rtsp_url string) error {
 		}
 	})
 
+	running.Add(1)
 	go func() {
-		// Open a H264 file and start reading using our IVFReader
-		cmd := exec.Command("ffmpeg", "-i", rtsp_url, "-c:v", "libx264",
-			"-an", "-bsf:v", "h264_mp4toannexb", "-b:v", "2M", "-max_delay", "0",
-			"-bf", "0", "-f", "h264", "pipe:1")
+		defer running.Done()
 
-		cmdStdOut, err := cmd.StdoutPipe()
-		if err != nil {
-			panic(err)
+		// Wait for connection established
+		select {
+		case <-iceConnectedCtx.Done():
+		case <-streamCtx.Done():
+			return
 		}
 
-		h264, h264Err := h264reader.NewReader(cmdStdOut)
-		if h264Err != nil {
-			panic(h264Err)
+		// Restart ffmpeg each time the camera source changes
+		rtsp_url := <-source
+		stats := frameStats{
+			frames: metrics.CameraFrames.WithLabelValues(t.Tenant, camera),
+			bytes:  metrics.CameraBytes.WithLabelValues(t.Tenant, camera),
 		}
-
-		// Wait for connection established
-		<-iceConnectedCtx.Done()
-
-		cmd.Start()
-		// Send our video file frame at a time. Pace our sending so we send it at the same speed it should be played back as.
-		// This isn't required since the video is timestamped, but we will such much higher loss if we send all at once.
-		//
-		// It is important to use a time.Ticker instead of time.Sleep because
-		// * avoids accumulating skew, just calling time.Sleep didn't compensate for the time spent parsing the data
-		// * works around latency issues with Sleep (see https://github.com/golang/go/issues/44343)
-		ticker := time.NewTicker(time.Millisecond * 33)
-		for ; true; <-ticker.C {
-			nal, h264Err := h264.NextNAL()
-			if h264Err == io.EOF {
-				log.Print("All video frames parsed and sent \n")
-				return
-			}
-			if h264Err != nil {
+		for {
+			next, err := writeH264(streamCtx, rtsp_url, videoTrack, source, stats)
+			if err != nil {
+				log.Print(err)
 				return
 			}
-			if h264Err = videoTrack.WriteSample(media.Sample{Data: nal.Data, Duration: time.Second}); h264Err != nil {
+			if next == "" {
+				log.Print("End stream video \n")
 				return
 			}
+			log.Printf("Restart stream video: %s \n", next)
+			metrics.FFmpegRestarts.WithLabelValues(t.Tenant, camera).Inc()
+			rtsp_url = next
 		}
-		log.Print("End stream video \n")
 	}()
 
 	// Send and Get JSON message for signaling
@@ -149,6 +157,7 @@ func (t *ThreadSafeWriter) WebRTCStreamH264(rtsp_url string) error {
 	msg := &message{}
 
 	go func() {
+		defer close(done) // connection closed by the client or revoked
 		for {
 			_, raw, err := t.Conn.ReadMessage()
 			if err != nil {
@@ -197,6 +206,79 @@ func (t *ThreadSafeWriter) WebRTCStreamH264(rtsp_url string) error {
 			}
 		}
 	}()
-	<-done
+	select {
+	case <-done:
+	case <-streamCtx.Done():
+	}
 	return nil
 }
+
+// Counters of the frames written to the track
+type frameStats struct {
+	frames prometheus.Counter
+	bytes  prometheus.Counter
+}
+
+// Transcode the RTSP stream to H264 with ffmpeg and write it to the track until
+// it ends, the context is done or a new URL is received, which is returned.
+func writeH264(ctx context.Context, rtsp_url string, videoTrack *webrtc.TrackLocalStaticSample, source <-chan string, stats frameStats) (string, error) {
+	cmdCtx, cmdCancel := context.WithCancel(ctx)
+	args := append(append([]string{}, FFmpeg.InputArgs...), "-i", rtsp_url, "-c:v", "libx264",
+		"-an", "-bsf:v", "h264_mp4toannexb", "-b:v", FFmpeg.Bitrate, "-max_delay", "0",
+		"-bf", "0", "-f", "h264", "pipe:1")
+	cmd := exec.CommandContext(cmdCtx, FFmpeg.Path, args...)
+
+	cmdStdOut, err := cmd.StdoutPipe()
+	if err != nil {
+		cmdCancel()
+		return "", err
+	}
+
+	h264, h264Err := h264reader.NewReader(cmdStdOut)
+	if h264Err != nil {
+		cmdCancel()
+		return "", h264Err
+	}
+
+	if err := cmd.Start(); err != nil {
+		cmdCancel()
+		return "", err
+	}
+	defer func() {
+		cmdCancel() // kill ffmpeg
+		cmd.Wait()
+	}()
+
+	// Send our video file frame at a time. Pace our sending so we send it at the same speed it should be played back as.
+	// This isn't required since the video is timestamped, but we will such much higher loss if we send all at once.
+	//
+	// It is important to use a time.Ticker instead of time.Sleep because
+	// * avoids accumulating skew, just calling time.Sleep didn't compensate for the time spent parsing the data
+	// * works around latency issues with Sleep (see https://github.com/golang/go/issues/44343)
+	ticker := time.NewTicker(time.Millisecond * 33)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		select {
+		case next := <-source:
+			return next, nil
+		case <-ctx.Done():
+			return "", nil
+		default:
+		}
+
+		nal, h264Err := h264.NextNAL()
+		if h264Err == io.EOF {
+			log.Print("All video frames parsed and sent \n")
+			return "", nil
+		}
+		if h264Err != nil {
+			return "", h264Err
+		}
+		if h264Err = videoTrack.WriteSample(media.Sample{Data: nal.Data, Duration: time.Second}); h264Err != nil {
+			return "", h264Err
+		}
+		stats.frames.Inc()
+		stats.bytes.Add(float64(len(nal.Data)))
+	}
+	return "", nil
+}